@@ -1,5 +1,8 @@
 module github.com/YakDriver/cachegoat
 
-go 1.25
+go 1.25.0
 
-require gopkg.in/yaml.v3 v3.0.1
+require (
+	golang.org/x/mod v0.40.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,17 @@
+//go:build !darwin && !linux
+
+package cleaner
+
+import "io/fs"
+
+// fileAllocated reports the bytes a file occupies on disk. Platforms without a
+// real implementation land here and report the apparent size instead.
+func fileAllocated(info fs.FileInfo) int64 {
+	return info.Size()
+}
+
+// hardLinkID reports no hard links on platforms without a real
+// implementation, so every path counts towards a cache's size.
+func hardLinkID(_ fs.FileInfo) (id fileID, ok bool) {
+	return fileID{}, false
+}
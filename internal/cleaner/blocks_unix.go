@@ -0,0 +1,31 @@
+//go:build darwin || linux
+
+package cleaner
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileAllocated returns the bytes a file occupies on disk, from the number of
+// 512-byte blocks allocated to it. This is what du reports: it rounds tiny
+// files up to the filesystem's block size and leaves out the holes in sparse
+// ones. It falls back to the apparent size if the block count is unavailable.
+func fileAllocated(info fs.FileInfo) int64 {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.Size()
+	}
+	return int64(st.Blocks) * 512
+}
+
+// hardLinkID identifies a file with more than one hard link by its device and
+// inode, so it can be counted once however many paths lead to it. ok is false
+// for a file with a single link, which needs no such bookkeeping.
+func hardLinkID(info fs.FileInfo) (id fileID, ok bool) {
+	st, isStat := info.Sys().(*syscall.Stat_t)
+	if !isStat || st.Nlink <= 1 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: st.Ino}, true
+}
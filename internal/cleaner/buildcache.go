@@ -0,0 +1,101 @@
+package cleaner
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// buildCacheEntries picks the evictable entries out of a scan of a build cache
+// root — the action ("-a") and output ("-d") files in GOCACHE's two-hex-digit
+// subdirectories — least recently used first.
+//
+// Recency comes from the modification time, not the access time. Go marks an
+// entry as used by bumping its mtime (at most once an hour), which is also what
+// its own trim relies on, whereas keep-warm deliberately advances atimes on
+// idle files and would make every one of them look freshly used.
+//
+// Anything else in the root — README, trim.txt, testexpire.txt, stray files —
+// is left alone so Go continues to recognize the directory as its cache.
+func buildCacheEntries(s *cacheScan) []*scanFile {
+	var entries []*scanFile
+	for _, f := range s.live() {
+		rel, err := filepath.Rel(s.root, f.path)
+		if err != nil {
+			continue
+		}
+		dir, name, ok := strings.Cut(filepath.ToSlash(rel), "/")
+		if !ok || !isHexByteDir(dir) || strings.Contains(name, "/") {
+			continue
+		}
+		if !strings.HasSuffix(name, "-a") && !strings.HasSuffix(name, "-d") {
+			continue
+		}
+		entries = append(entries, f)
+	}
+
+	slices.SortStableFunc(entries, func(a, b *scanFile) int {
+		return a.mtime.Compare(b.mtime)
+	})
+	return entries
+}
+
+// isHexByteDir reports whether name is one of the "00".."ff" subdirectories Go
+// shards its build cache into.
+func isHexByteDir(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	for _, r := range name {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// trimBuildCache evicts least-recently-used entries from the build cache until
+// its size (currently size bytes) is at or below target bytes. entries must be
+// ordered least recently used first, as buildCacheEntries returns them. It
+// returns the number of entries removed and the bytes reclaimed. In dry-run
+// mode nothing is deleted, but the counts reflect what would be.
+func (c *Cleaner) trimBuildCache(entries []*scanFile, size, target int64) (removed int, reclaimed int64) {
+	for _, e := range entries {
+		if size-reclaimed <= target {
+			break
+		}
+		if !c.dryRun {
+			if err := os.Remove(e.path); err != nil {
+				continue
+			}
+			e.removed = true
+		}
+		removed++
+		reclaimed += e.size
+	}
+	return removed, reclaimed
+}
+
+// expireBuildCache removes every entry not used within maxAge. It returns the
+// surviving entries, still least recently used first, along with the number
+// of entries removed and the bytes reclaimed.
+func (c *Cleaner) expireBuildCache(entries []*scanFile, maxAge time.Duration) (survivors []*scanFile, removed int, reclaimed int64) {
+	cutoff := time.Now().Add(-maxAge)
+	for i, e := range entries {
+		if !e.mtime.Before(cutoff) {
+			return append(survivors, entries[i:]...), removed, reclaimed
+		}
+		if !c.dryRun {
+			if err := os.Remove(e.path); err != nil {
+				survivors = append(survivors, e)
+				continue
+			}
+			e.removed = true
+		}
+		removed++
+		reclaimed += e.size
+	}
+	return survivors, removed, reclaimed
+}
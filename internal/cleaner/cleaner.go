@@ -2,23 +2,67 @@ package cleaner
 
 import (
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"time"
 
 	"github.com/YakDriver/cachegoat/internal/config"
 )
 
 type Cleaner struct {
-	cfg    *config.Config
-	dryRun bool
-	force  bool
-	log    *os.File
+	cfg         *config.Config // the profile being cleaned
+	base        *config.Config
+	only        string // the one profile to clean, or "" for all
+	profile     string // the profile being cleaned, when there are several
+	dryRun      bool
+	force       bool
+	wait        time.Duration // how long to wait for builds to finish
+	lockTimeout time.Duration // how long to wait for another run's lock on a cache
+	log         *os.File
+	out         io.Writer               // where log lines are echoed
+	ledgers     map[string]*usageLedger // by cache path
+	scans       map[string]*cacheScan   // by cache path
+	refused     map[string]error        // by cache path; nil if safe to clean
+	report      Report
 }
 
 func New(cfg *config.Config, dryRun, force bool) *Cleaner {
-	return &Cleaner{cfg: cfg, dryRun: dryRun, force: force}
+	return &Cleaner{
+		cfg:     cfg,
+		base:    cfg,
+		dryRun:  dryRun,
+		force:   force,
+		wait:    time.Duration(cfg.ProtectBuildsWait),
+		out:     os.Stdout,
+		ledgers: make(map[string]*usageLedger),
+		scans:   make(map[string]*cacheScan),
+		refused: make(map[string]error),
+		report:  Report{DryRun: dryRun, Caches: []*CacheReport{}},
+	}
+}
+
+// SetProfile limits runs to the named profile (see config.Profile), rather
+// than every profile.
+func (c *Cleaner) SetProfile(name string) {
+	c.only = name
+}
+
+// SetWait sets how long runs wait for builds to finish before skipping
+// cleanup, overriding protect_builds_wait.
+func (c *Cleaner) SetWait(d time.Duration) {
+	c.wait = d
+}
+
+// SetLockTimeout sets how long runs wait for another cachegoat run to let go
+// of a cache before skipping it, rather than skipping it at once.
+func (c *Cleaner) SetLockTimeout(d time.Duration) {
+	c.lockTimeout = d
+}
+
+// SetOutput redirects the log lines a run echoes, which go to stdout by
+// default. The log file, if any, is unaffected.
+func (c *Cleaner) SetOutput(w io.Writer) {
+	c.out = w
 }
 
 func (c *Cleaner) Run() error {
@@ -29,89 +73,325 @@ func (c *Cleaner) Run() error {
 		}
 	}
 
-	var buildPurged, modPurged bool
-	if c.cfg.ProtectBuilds && !c.force && goBuildActive() {
-		// A build is running: skip the destructive purge, but still keep the
-		// cache warm below. Refreshing access times is harmless mid-build and
-		// is exactly when idle dependencies most need protecting.
-		c.logf("Go build active, skipping cache purge (use --force to override)")
-	} else {
-		buildPurged = c.cleanBuildCache()
-		modPurged = c.cleanModCache()
+	names := c.base.ProfileNames()
+	if c.only != "" {
+		names = []string{c.only}
+	}
+	buildActive := c.buildBlocked()
+	for _, name := range names {
+		cfg, ok := c.base.Profile(name)
+		if !ok {
+			c.failf("", "unknown profile %q", name)
+			continue
+		}
+		c.cfg = cfg
+		if len(c.base.Profiles) > 0 {
+			c.profile = name
+		}
+		c.runProfile(buildActive)
+	}
+	c.cfg, c.profile = c.base, ""
+	return nil
+}
+
+// runProfile cleans, then keeps warm, the caches of the current profile,
+// holding the lock on each throughout. What it learned of each cache's use
+// is saved before the lock goes, so runs don't overwrite each other's.
+func (c *Cleaner) runProfile(buildActive bool) {
+	caches, locks := c.lockCaches(c.caches())
+	defer func() {
+		if !c.dryRun {
+			for _, n := range caches {
+				c.saveUsage(n.Path)
+			}
+		}
+		for _, l := range locks {
+			l.release()
+		}
+	}()
+	whileLocked(caches)
+
+	purged := make(map[string]bool)
+	if !buildActive {
+		for _, n := range caches {
+			switch n.Kind {
+			case config.KindBuild:
+				purged[n.Path] = c.cleanBuildCache()
+			case config.KindMod:
+				purged[n.Path] = c.cleanModCache()
+			default:
+				purged[n.Path] = c.cleanNamedCache(n)
+			}
+		}
 	}
 
 	// Keep surviving cache files warm so OS temp cleaners don't prune them and
 	// leave the cache half-populated. This runs regardless of build activity.
 	// Skip a cache that was just purged: it is empty (or nearly so), and there
-	// is nothing worth keeping warm.
-	if c.cfg.KeepWarm {
-		if !buildPurged {
-			c.keepWarm(c.cfg.BuildCache.Path)
+	// is nothing worth keeping warm. Other tools' caches are only warmed where
+	// a temp cleaner can reach them, without reporting the rest as skipped.
+	for _, n := range caches {
+		if !n.WarmEnabled(c.cfg.KeepWarm) || purged[n.Path] {
+			continue
 		}
-		if !modPurged {
-			c.keepWarm(c.cfg.ModCache.Path)
+		if n.Kind != config.KindBuild && n.Kind != config.KindMod && !c.underTempCleaner(n.Path) {
+			continue
 		}
+		c.keepWarm(n.Path)
 	}
-	return nil
+
+	if !c.dryRun {
+		c.recordLocations()
+	}
+}
+
+// caches returns the caches this run manages: Go's build and module caches,
+// and every other configured cache that exists.
+func (c *Cleaner) caches() []config.NamedCache {
+	var caches []config.NamedCache
+	for _, n := range c.cfg.AllCaches() {
+		if n.Kind != config.KindBuild && n.Kind != config.KindMod {
+			if _, err := os.Stat(n.Path); err != nil {
+				continue
+			}
+		}
+		caches = append(caches, n)
+	}
+	return caches
 }
 
+// cleanBuildCache enforces the build cache's age, size and free-space limits.
+// It reports whether the cache was purged outright; a trim leaves survivors
+// behind that are still worth keeping warm, so it reports false.
 func (c *Cleaner) cleanBuildCache() bool {
-	path := c.cfg.BuildCache.Path
-	if path == "" {
+	cc := c.cfg.BuildCache
+	path := cc.Path
+	if path == "" || !c.safeToClean(path) {
 		return false
 	}
-	sizeGB := dirSizeGB(path)
-	c.logf("build cache: %s (%.1fGB)", path, sizeGB)
+	s := c.scan(path)
+	size := s.size
+	c.logf("build cache: %s (%s)", path, s.describe(cc))
+	total, avail := c.freeSpace("build cache", cc, path)
+	c.reportSize(cc, s, total, avail)
 
-	if sizeGB >= float64(c.cfg.BuildCache.MaxSizeGB) {
-		c.logf("purging build cache (>=%.0fGB threshold)", float64(c.cfg.BuildCache.MaxSizeGB))
+	var freed int64
+	entries := buildCacheEntries(s)
+	if cc.MaxAge > 0 {
+		var removed int
+		entries, removed, freed = c.expireBuildCache(entries, time.Duration(cc.MaxAge))
+		if removed > 0 {
+			verb := "expired"
+			if c.dryRun {
+				verb = "would expire"
+			}
+			c.logf("build cache: %s %d entries unused for %s, reclaiming %.1fGB", verb, removed, cc.MaxAge, toGB(freed))
+		}
+		c.record(path, ActionExpire, removed, freed)
+	}
+	defer func() { c.logProjectedFree("build cache", avail, freed) }()
+
+	plan := planCleanup(cc, size-freed, total, avail+freed)
+	if !plan.clean {
+		return false
+	}
+	c.reportPlan(path, plan)
+
+	if cc.Purge() {
+		c.logf("purging build cache (%s)", plan.reason)
 		if !c.dryRun {
-			_ = exec.Command("go", "clean", "-cache").Run()
+			if err := goClean("-cache", "GOCACHE", path); err != nil {
+				c.failf(path, "build cache: %v", err)
+				return false
+			}
 		}
+		c.record(path, ActionPurge, len(s.live()), size-freed)
+		freed = size
 		return true
 	}
+
+	c.logf("trimming build cache to %.1fGB (%s)", toGB(plan.target), plan.reason)
+	removed, reclaimed := c.trimBuildCache(entries, size-freed, plan.target)
+	freed += reclaimed
+	verb := "trimmed"
+	if c.dryRun {
+		verb = "would trim"
+	}
+	c.logf("build cache: %s %d entries, reclaiming %.1fGB", verb, removed, toGB(reclaimed))
+	c.record(path, ActionTrim, removed, reclaimed)
 	return false
 }
 
+// cleanModCache enforces the module cache's version, age, size and free-space
+// limits. Like cleanBuildCache, it reports whether the cache was purged
+// outright.
 func (c *Cleaner) cleanModCache() bool {
-	path := c.cfg.ModCache.Path
-	if path == "" {
+	cc := c.cfg.ModCache
+	path := cc.Path
+	if path == "" || !c.safeToClean(path) {
+		return false
+	}
+	s := c.scan(path)
+	size := s.size
+	c.logf("mod cache: %s (%s)", path, s.describe(cc))
+	total, avail := c.freeSpace("mod cache", cc, path)
+	c.reportSize(cc, s, total, avail)
+
+	// Pinned versions survive any selective eviction. Scanning workspaces can
+	// be slow on large source trees, so only do it when something may be
+	// evicted.
+	var pins pinSet
+	if cc.KeepVersions > 0 || cc.MaxAge > 0 || (planCleanup(cc, size, total, avail).clean && !cc.Purge()) {
+		pins = referencedVersions(cc.KeepReferenced)
+		pins.addWorkspaces(c.cfg.ProtectWorkspaces)
+	}
+
+	var freed int64
+	defer func() { c.logProjectedFree("mod cache", avail, freed) }()
+
+	versions := modCacheVersions(s, c.usage(path))
+	if cc.KeepVersions > 0 {
+		before := len(versions)
+		var reclaimed int64
+		versions, reclaimed = c.pruneModVersions(path, versions, cc.KeepVersions, pins)
+		freed += reclaimed
+		c.record(path, ActionPrune, before-len(versions), reclaimed)
+	}
+	if cc.MaxAge > 0 {
+		before := len(versions)
+		var reclaimed int64
+		versions, reclaimed = c.expireModVersions(path, versions, pins, time.Duration(cc.MaxAge))
+		freed += reclaimed
+		c.record(path, ActionExpire, before-len(versions), reclaimed)
+	}
+
+	plan := planCleanup(cc, size-freed, total, avail+freed)
+	if !plan.clean {
 		return false
 	}
-	sizeGB := dirSizeGB(path)
-	c.logf("mod cache: %s (%.1fGB)", path, sizeGB)
+	c.reportPlan(path, plan)
 
-	if sizeGB >= float64(c.cfg.ModCache.MaxSizeGB) {
-		c.logf("purging mod cache (>=%.0fGB threshold)", float64(c.cfg.ModCache.MaxSizeGB))
+	if cc.Purge() {
+		c.logf("purging mod cache (%s)", plan.reason)
 		if !c.dryRun {
-			_ = exec.Command("go", "clean", "-modcache").Run()
+			if err := goClean("-modcache", "GOMODCACHE", path); err != nil {
+				c.failf(path, "mod cache: %v", err)
+				return false
+			}
 		}
+		c.record(path, ActionPurge, len(versions), size-freed)
+		freed = size
 		return true
 	}
+
+	c.logf("trimming mod cache to %.1fGB (%s)", toGB(plan.target), plan.reason)
+	removed, reclaimed := c.trimModCache(path, versions, pins, size-freed, plan.target)
+	freed += reclaimed
+	verb := "trimmed"
+	if c.dryRun {
+		verb = "would trim"
+	}
+	c.logf("mod cache: %s %d module versions, reclaiming %.1fGB", verb, removed, toGB(reclaimed))
+	c.record(path, ActionTrim, removed, reclaimed)
 	return false
 }
 
+// cleanupPlan is what a cache's size and free-space triggers call for.
+type cleanupPlan struct {
+	clean  bool   // a trigger fired
+	target int64  // size in bytes to shrink the cache to
+	reason string // which trigger fired, for logging
+}
+
+// planCleanup decides whether a cache of size bytes must shrink, and to what.
+// The size trigger fires at the high-water mark and shrinks the cache to the
+// low-water mark. The free-space trigger fires when avail, the free bytes on
+// the cache's filesystem of total bytes (negative if unknown), is below
+// min_free, and shrinks the cache by at least the shortfall. When both fire,
+// the smaller target wins.
+func planCleanup(cc config.CacheConfig, size, total, avail int64) cleanupPlan {
+	var p cleanupPlan
+	if high, low := cc.Limits(total); high >= 0 && size >= high {
+		p = cleanupPlan{clean: true, target: low, reason: fmt.Sprintf(">=%.1fGB threshold", toGB(high))}
+	}
+	if minFree := cc.MinFree.Resolve(total); minFree > 0 && avail >= 0 && avail < minFree {
+		target := max(size-(minFree-avail), 0)
+		if !p.clean || target < p.target {
+			p = cleanupPlan{clean: true, target: target, reason: fmt.Sprintf("%.1fGB free, below %.1fGB min_free", toGB(avail), toGB(minFree))}
+		}
+	}
+	return p
+}
+
+// freeSpace returns the total size of the filesystem holding a cache and the
+// space free on it, logging the latter when min_free is configured. avail is
+// -1 when it can't be determined.
+func (c *Cleaner) freeSpace(name string, cc config.CacheConfig, path string) (total, avail int64) {
+	total, avail, ok := diskSpace(path)
+	if !ok {
+		return 0, -1
+	}
+	if !cc.MinFree.IsZero() {
+		c.logf("%s: %.1fGB free on its filesystem (min_free %s)", name, toGB(avail), cc.MinFree)
+	}
+	return total, avail
+}
+
+// reportSize records a cache's size, threshold and free space in the report.
+func (c *Cleaner) reportSize(cc config.CacheConfig, s *cacheScan, total, avail int64) {
+	cr := c.cacheReport(cc.Path)
+	cr.Measure = config.MeasureAllocated
+	if cc.Apparent() {
+		cr.Measure = config.MeasureApparent
+	}
+	cr.SizeBytes, cr.AllocatedBytes, cr.ApparentBytes = s.size, s.allocated, s.apparent
+	cr.ThresholdBytes, _ = cc.Limits(total)
+	cr.FreeBytes = avail
+}
+
+// reportPlan records why the cache at path is being cleaned, and to what size.
+func (c *Cleaner) reportPlan(path string, plan cleanupPlan) {
+	cr := c.cacheReport(path)
+	cr.TargetBytes, cr.Reason = plan.target, plan.reason
+}
+
+// logProjectedFree reports the free space a cleanup leaves behind, when it
+// freed anything. In dry-run mode this is the projection of what would be
+// freed.
+func (c *Cleaner) logProjectedFree(name string, avail, freed int64) {
+	if avail < 0 || freed == 0 {
+		return
+	}
+	verb := "leaves"
+	if c.dryRun {
+		verb = "would leave"
+	}
+	c.logf("%s: cleanup %s %.1fGB free (was %.1fGB)", name, verb, toGB(avail+freed), toGB(avail))
+}
+
 func (c *Cleaner) logf(format string, args ...any) {
-	msg := fmt.Sprintf("%s: %s", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
-	fmt.Println(msg)
+	msg := fmt.Sprintf(format, args...)
+	if c.profile != "" {
+		msg = "[" + c.profile + "] " + msg
+	}
+	msg = fmt.Sprintf("%s: %s", time.Now().Format(time.RFC3339), msg)
+	_, _ = fmt.Fprintln(c.out, msg)
 	if c.log != nil {
 		_, _ = fmt.Fprintln(c.log, msg)
 	}
 }
 
-// goBuildActive reports whether a Go build/test/install/run process is
-// currently running. It is a var so tests can substitute it.
-var goBuildActive = func() bool {
-	return exec.Command("pgrep", "-qf", "go (build|test|install|run)").Run() == nil
-}
+const bytesPerGB = 1024 * 1024 * 1024
+
+func toGB(n int64) float64 { return float64(n) / bytesPerGB }
+
+func toMB(n int64) float64 { return float64(n) / (1024 * 1024) }
 
 func dirSizeGB(path string) float64 {
-	var size int64
-	_ = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
-		if err == nil && !info.IsDir() {
-			size += info.Size()
-		}
-		return nil
-	})
-	return float64(size) / (1024 * 1024 * 1024)
+	return toGB(dirSize(path))
+}
+
+// dirSize returns the bytes allocated on disk to the files under path.
+func dirSize(path string) int64 {
+	return scanTree(path, false).size
 }
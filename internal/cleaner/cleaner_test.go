@@ -1,6 +1,8 @@
 package cleaner
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -42,6 +44,7 @@ func TestDirSizeGB_NonExistent(t *testing.T) {
 
 func TestCleanerDryRun(t *testing.T) {
 	tmp := t.TempDir()
+	markBuildCache(t, tmp)
 	testFile := filepath.Join(tmp, "test.bin")
 	if err := os.WriteFile(testFile, make([]byte, 1024), 0644); err != nil {
 		t.Fatal(err)
@@ -63,57 +66,289 @@ func TestCleanerDryRun(t *testing.T) {
 	}
 }
 
-func TestCleanBuildCache(t *testing.T) {
-	tmp := t.TempDir()
-	testFile := filepath.Join(tmp, "test.bin")
-	if err := os.WriteFile(testFile, make([]byte, 1024), 0644); err != nil {
+func TestRunReport(t *testing.T) {
+	root := t.TempDir()
+	markBuildCache(t, root)
+	p := writeBuildEntry(t, root, "aa01-d", 100, 72*time.Hour)
+	writeBuildEntry(t, root, "bb02-a", 100, time.Hour)
+	var entrySize int64
+	for _, e := range buildCacheEntries(scanTree(root, false)) {
+		entrySize += e.size
+	}
+
+	cfg := &config.Config{BuildCache: config.CacheConfig{Path: root, MaxSize: config.Size{Bytes: 1}}}
+	c := New(cfg, true, false) // dry-run
+	c.SetOutput(io.Discard)
+	if err := c.Run(); err != nil {
 		t.Fatal(err)
 	}
 
-	cfg := &config.Config{
-		BuildCache:    config.CacheConfig{Path: tmp, MaxSizeGB: 0},
-		ProtectBuilds: false,
-		LogPath:       "",
+	r := c.Report()
+	if !r.DryRun || len(r.Caches) != 1 {
+		t.Fatalf("report = %+v, want one cache in a dry run", r)
 	}
+	cr := r.Caches[0]
+	if cr.Name != "build" || cr.Path != root || cr.Measure != config.MeasureAllocated {
+		t.Errorf("name=%q path=%q measure=%q", cr.Name, cr.Path, cr.Measure)
+	}
+	if cr.ThresholdBytes != 1 || cr.SizeBytes == 0 || cr.Reason == "" {
+		t.Errorf("threshold=%d size=%d reason=%q", cr.ThresholdBytes, cr.SizeBytes, cr.Reason)
+	}
+	if len(cr.Actions) != 1 || cr.Actions[0] != ActionTrim || cr.Removed != 2 || cr.ReclaimedBytes != entrySize {
+		t.Errorf("actions=%v removed=%d reclaimed=%d, want a trim of both entries", cr.Actions, cr.Removed, cr.ReclaimedBytes)
+	}
+	if _, err := os.Stat(p); err != nil {
+		t.Errorf("dry-run must not delete entries: %v", err)
+	}
+}
 
+// TestPurgeCleansMeasuredPath checks a purge empties the configured cache,
+// not whatever Go's own settings point at.
+func TestPurgeCleansMeasuredPath(t *testing.T) {
+	build, mod := t.TempDir(), t.TempDir()
+	markBuildCache(t, build)
+	entry := writeBuildEntry(t, build, "aa01-d", 100, 0)
+	download := filepath.Join(mod, "cache", "download", "example.com", "a", "@v")
+	if err := os.MkdirAll(download, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(download, "v1.0.0.zip"), make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	purge := config.CacheConfig{MaxSize: config.Size{Bytes: 1}, Strategy: config.StrategyPurge}
+	cfg := &config.Config{BuildCache: purge, ModCache: purge}
+	cfg.BuildCache.Path, cfg.ModCache.Path = build, mod
 	c := New(cfg, false, false)
+	c.SetOutput(io.Discard)
 	if err := c.Run(); err != nil {
 		t.Fatal(err)
 	}
 
-	// Note: go clean -cache cleans the actual Go cache, not our test directory
-	// This test verifies the command runs without error
+	for _, cr := range c.Report().Caches {
+		if len(cr.Errors) > 0 || len(cr.Actions) != 1 || cr.Actions[0] != ActionPurge {
+			t.Errorf("%s cache: actions=%v errors=%v, want a purge", cr.Name, cr.Actions, cr.Errors)
+		}
+	}
+	if _, err := os.Stat(entry); !os.IsNotExist(err) {
+		t.Error("the build cache entry should have been purged")
+	}
+	if _, err := os.Stat(filepath.Join(mod, "cache")); !os.IsNotExist(err) {
+		t.Error("the module cache should have been purged")
+	}
 }
 
-func TestCleanModCache(t *testing.T) {
-	tmp := t.TempDir()
+func TestRefusesUnrecognizedCache(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	notCache := t.TempDir()
+	f := filepath.Join(notCache, "precious.txt")
+	if err := os.WriteFile(f, make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
 
-	oldFile := filepath.Join(tmp, "old.bin")
-	newFile := filepath.Join(tmp, "new.bin")
+	purge := config.CacheConfig{MaxSize: config.Size{Bytes: 1}, Strategy: config.StrategyPurge}
+	for _, tc := range []struct{ name, build, mod string }{
+		{"not a cache", notCache, notCache},
+		{"home", home, home},
+		{"root", "/", "/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{BuildCache: purge, ModCache: purge, KeepWarm: true}
+			cfg.BuildCache.Path, cfg.ModCache.Path = tc.build, tc.mod
+			c := New(cfg, false, false)
+			c.SetOutput(io.Discard)
+			if err := c.Run(); err != nil {
+				t.Fatal(err)
+			}
+			for _, cr := range c.Report().Caches {
+				if cr.Skipped == "" || len(cr.Errors) == 0 || len(cr.Actions) > 0 {
+					t.Errorf("%s: skipped=%q errors=%v actions=%v, want a refusal", cr.Path, cr.Skipped, cr.Errors, cr.Actions)
+				}
+			}
+		})
+	}
+	if _, err := os.Stat(f); err != nil {
+		t.Errorf("nothing outside a Go cache should be touched: %v", err)
+	}
+}
+
+// markBuildCache writes the README Go puts in a build cache into dir.
+func markBuildCache(t *testing.T, dir string) {
+	t.Helper()
+	readme := "This directory holds cached build artifacts from the Go build system.\nRun \"go clean -cache\" if the directory is getting too large.\n"
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte(readme), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
 
-	if err := os.WriteFile(oldFile, make([]byte, 1024), 0644); err != nil {
+// writeBuildEntry creates a build-cache entry of the given size whose last use
+// (modification time) is age ago, returning its path.
+func writeBuildEntry(t *testing.T, root, name string, size int, age time.Duration) string {
+	t.Helper()
+	dir := filepath.Join(root, name[:2])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, make([]byte, size), 0644); err != nil {
 		t.Fatal(err)
 	}
-	if err := os.WriteFile(newFile, make([]byte, 1024), 0644); err != nil {
+	when := time.Now().Add(-age)
+	if err := os.Chtimes(p, when, when); err != nil {
 		t.Fatal(err)
 	}
+	return p
+}
 
-	// Make old file 10 days old
-	oldTime := time.Now().AddDate(0, 0, -10)
-	if err := os.Chtimes(oldFile, oldTime, oldTime); err != nil {
+func TestTrimBuildCacheEvictsOldestFirst(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "README"), []byte("go cache"), 0644); err != nil {
 		t.Fatal(err)
 	}
+	oldest := writeBuildEntry(t, root, "aa01-d", 100, 72*time.Hour)
+	older := writeBuildEntry(t, root, "bb02-a", 100, 48*time.Hour)
+	recent := writeBuildEntry(t, root, "cc03-d", 100, time.Hour)
 
-	cfg := &config.Config{
-		ModCache:      config.CacheConfig{Path: tmp, MaxSizeGB: 0},
-		ProtectBuilds: false,
+	// Sizes are allocated bytes, so take them from the scan rather than
+	// assuming a block size.
+	entries := buildCacheEntries(scanTree(root, false))
+	unit := entries[0].size
+	c := New(&config.Config{}, false, false)
+	removed, reclaimed := c.trimBuildCache(entries, 3*unit, 3*unit/2)
+
+	if removed != 2 || reclaimed != 2*unit {
+		t.Fatalf("removed=%d reclaimed=%d, want 2/%d", removed, reclaimed, 2*unit)
+	}
+	for _, p := range []string{oldest, older} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s should have been evicted", filepath.Base(p))
+		}
+	}
+	for _, p := range []string{recent, filepath.Join(root, "README")} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s should have survived: %v", filepath.Base(p), err)
+		}
 	}
+}
 
-	c := New(cfg, false, false)
-	if err := c.Run(); err != nil {
-		t.Fatal(err)
+func TestTrimBuildCacheDryRun(t *testing.T) {
+	root := t.TempDir()
+	p := writeBuildEntry(t, root, "aa01-d", 100, 72*time.Hour)
+
+	entries := buildCacheEntries(scanTree(root, false))
+	c := New(&config.Config{}, true, false) // dry-run
+	removed, reclaimed := c.trimBuildCache(entries, entries[0].size, 0)
+
+	if removed != 1 || reclaimed != entries[0].size {
+		t.Fatalf("removed=%d reclaimed=%d, want 1/%d", removed, reclaimed, entries[0].size)
+	}
+	if _, err := os.Stat(p); err != nil {
+		t.Errorf("dry-run must not delete entries: %v", err)
 	}
+}
+
+func TestBuildCacheEntriesSkipsNonEntries(t *testing.T) {
+	root := t.TempDir()
+	writeBuildEntry(t, root, "aa01-a", 1, time.Hour)
+	for _, p := range []string{"trim.txt", "aa/not-an-entry", "zz/ff01-d"} {
+		full := filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := buildCacheEntries(scanTree(root, false))
+	if len(entries) != 1 || filepath.Base(entries[0].path) != "aa01-a" {
+		t.Errorf("expected only aa01-a, got %+v", entries)
+	}
+}
 
-	// Note: go clean -modcache cleans the actual Go module cache, not our test directory
-	// This test verifies the command runs without error
+func TestExpireBuildCache(t *testing.T) {
+	root := t.TempDir()
+	stale := writeBuildEntry(t, root, "aa01-d", 100, 40*24*time.Hour)
+	fresh := writeBuildEntry(t, root, "bb02-a", 100, 2*24*time.Hour)
+
+	entries := buildCacheEntries(scanTree(root, false))
+	c := New(&config.Config{}, false, false)
+	survivors, removed, reclaimed := c.expireBuildCache(entries, 30*24*time.Hour)
+
+	if removed != 1 || reclaimed != entries[0].size || len(survivors) != 1 {
+		t.Fatalf("removed=%d reclaimed=%d survivors=%d, want 1/%d/1", removed, reclaimed, len(survivors), entries[0].size)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("stale entry should have expired")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("fresh entry should survive: %v", err)
+	}
+}
+
+func TestPlanCleanup(t *testing.T) {
+	const gib = 1 << 30
+	cc := config.CacheConfig{
+		MaxSize:    config.Size{Bytes: 30 * gib},
+		TargetSize: config.Size{Bytes: 20 * gib},
+		MinFree:    config.Size{Percent: 20}, // of a 100GiB filesystem: 20GiB
+	}
+	cases := []struct {
+		name        string
+		size, avail int64
+		clean       bool
+		target      int64
+	}{
+		{"under both limits", 10 * gib, 50 * gib, false, 0},
+		{"over high-water mark", 30 * gib, 50 * gib, true, 20 * gib},
+		{"low on free space", 10 * gib, 14 * gib, true, 4 * gib},
+		{"both, low-water mark is lower", 30 * gib, 15 * gib, true, 20 * gib},
+		{"both, shortfall needs more", 30 * gib, 5 * gib, true, 15 * gib},
+		{"shortfall larger than cache", 2 * gib, 1 * gib, true, 0},
+		{"free space unknown", 10 * gib, -1, false, 0},
+	}
+	for _, c := range cases {
+		p := planCleanup(cc, c.size, 100*gib, c.avail)
+		if p.clean != c.clean || (p.clean && p.target != c.target) {
+			t.Errorf("%s: plan = %+v, want clean=%t target=%d", c.name, p, c.clean, c.target)
+		}
+	}
+}
+
+func TestRunProfiles(t *testing.T) {
+	top, other := t.TempDir(), t.TempDir()
+	for _, root := range []string{top, other} {
+		markBuildCache(t, root)
+		writeBuildEntry(t, root, "aa01-d", 100, 72*time.Hour)
+	}
+	cfg := &config.Config{
+		BuildCache: config.CacheConfig{Path: top, MaxSize: config.Size{Bytes: 1}},
+		Profiles:   []config.Profile{{Name: "other", BuildCache: config.CacheConfig{Path: other}}},
+	}
+
+	for _, tc := range []struct {
+		only string
+		want []string // profile/path of each cache report
+	}{
+		{"", []string{"default/" + top, "other/" + other}},
+		{"other", []string{"other/" + other}},
+	} {
+		c := New(cfg, true, false) // dry-run
+		c.SetOutput(io.Discard)
+		c.SetProfile(tc.only)
+		if err := c.Run(); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, cr := range c.Report().Caches {
+			got = append(got, cr.Profile+"/"+cr.Path)
+			if len(cr.Actions) != 1 || cr.Actions[0] != ActionTrim {
+				t.Errorf("%s: actions=%v, want a trim under the inherited max_size", cr.Path, cr.Actions)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("profile %q: cleaned %v, want %v", tc.only, got, tc.want)
+		}
+	}
 }
@@ -0,0 +1,119 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+// Polling for builds to finish starts at firstPoll and backs off, doubling,
+// to maxPoll.
+const (
+	firstPoll = 10 * time.Second
+	maxPoll   = 5 * time.Minute
+)
+
+// sleep is a var so tests can wait without waiting.
+var sleep = time.Sleep
+
+// deferrals is how many runs in a row have skipped cleanup for a build,
+// remembered between runs so max_deferrals can put an end to it.
+type deferrals struct {
+	Count int       `json:"count"`
+	Since time.Time `json:"since"` // when the first of them ran
+}
+
+func deferralsFile(cfg *config.Config) string {
+	if cfg.StateDir == "" {
+		return ""
+	}
+	return filepath.Join(cfg.StateDir, "deferrals.json")
+}
+
+func loadDeferrals(cfg *config.Config) deferrals {
+	var d deferrals
+	if file := deferralsFile(cfg); file != "" {
+		if data, err := os.ReadFile(file); err == nil {
+			_ = json.Unmarshal(data, &d)
+		}
+	}
+	return d
+}
+
+func (d deferrals) save(cfg *config.Config) error {
+	file := deferralsFile(cfg)
+	if file == "" {
+		return nil
+	}
+	return writeJSONAtomic(file, d)
+}
+
+// buildBlocked reports whether a running build keeps this run from cleaning,
+// once protect_builds_wait is up. After max_deferrals runs in a row were kept
+// from it, the next cleans anyway. A dry run neither waits nor counts.
+func (c *Cleaner) buildBlocked() bool {
+	if !c.base.ProtectBuilds || c.force {
+		return false
+	}
+	b := c.waitForBuilds()
+	d := loadDeferrals(c.base)
+	persist := func(d deferrals) {
+		if c.dryRun {
+			return
+		}
+		if err := d.save(c.base); err != nil {
+			c.failf("", "saving deferrals: %v", err)
+		}
+	}
+	if b == nil {
+		if d.Count > 0 {
+			persist(deferrals{})
+		}
+		return false
+	}
+	if max := c.base.MaxDeferrals; max > 0 && d.Count >= max {
+		c.logf("Go build active (PID %d: %s), but cleanup was skipped %d runs in a row since %s (max_deferrals), cleaning anyway",
+			b.PID, b.Args, d.Count, d.Since.Format(time.RFC3339))
+		persist(deferrals{})
+		return false
+	}
+
+	// A build is running: skip the destructive purge, but still keep the
+	// caches warm. Refreshing access times is harmless mid-build and is
+	// exactly when idle dependencies most need protecting.
+	c.logf("Go build active (PID %d: %s), skipping cache purge (use --force to override)", b.PID, b.Args)
+	c.report.Skipped = "go build active"
+	c.report.BlockedBy = b
+	if d.Count == 0 {
+		d.Since = time.Now()
+	}
+	d.Count++
+	c.report.Deferrals = d.Count
+	persist(d)
+	return true
+}
+
+// waitForBuilds returns a running build protect_commands matches, if one is
+// still running once it has waited up to the configured time for builds to
+// finish, polling every firstPoll at first and backing off from there.
+func (c *Cleaner) waitForBuilds() *Blocker {
+	b := goBuildActive(c.base.ProtectCommands)
+	if b == nil || c.wait <= 0 || c.dryRun {
+		return b
+	}
+	c.logf("Go build active (PID %d: %s), waiting up to %s for builds to finish", b.PID, b.Args, config.Duration(c.wait))
+	var waited time.Duration
+	for poll := firstPoll; b != nil && waited < c.wait; poll = min(2*poll, maxPoll) {
+		d := min(poll, c.wait-waited)
+		sleep(d)
+		waited += d
+		b = goBuildActive(c.base.ProtectCommands)
+	}
+	if b == nil {
+		c.logf("builds finished after %s", config.Duration(waited))
+	}
+	return b
+}
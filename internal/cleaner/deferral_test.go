@@ -0,0 +1,101 @@
+package cleaner
+
+import (
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+// fakeBuilds makes the next active calls to goBuildActive find a build and
+// records the sleeps waiting for it takes.
+func fakeBuilds(t *testing.T, active int) *[]time.Duration {
+	origActive, origSleep := goBuildActive, sleep
+	t.Cleanup(func() { goBuildActive, sleep = origActive, origSleep })
+	goBuildActive = func([]string) *Blocker {
+		if active == 0 {
+			return nil
+		}
+		active--
+		return &Blocker{PID: 42, Command: "go test", Args: "go test ./..."}
+	}
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	return &slept
+}
+
+func TestWaitForBuilds(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		active  int
+		wait    time.Duration
+		slept   []time.Duration
+		skipped bool
+	}{
+		{"finishes", 3, time.Hour, []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second}, false},
+		{"times out", 100, 45 * time.Second, []time.Duration{10 * time.Second, 20 * time.Second, 15 * time.Second}, true},
+		{"no wait", 1, 0, nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			slept := fakeBuilds(t, tc.active)
+			dir := t.TempDir()
+			markBuildCache(t, dir)
+			cfg := &config.Config{BuildCache: config.CacheConfig{Path: dir}, ProtectBuilds: true}
+			c := New(cfg, false, false)
+			c.SetOutput(io.Discard)
+			c.SetWait(tc.wait)
+			if err := c.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(*slept, tc.slept) {
+				t.Errorf("slept %v, want %v", *slept, tc.slept)
+			}
+			if skipped := c.Report().Skipped != ""; skipped != tc.skipped {
+				t.Errorf("skipped = %v, want %v", skipped, tc.skipped)
+			}
+		})
+	}
+}
+
+func TestMaxDeferrals(t *testing.T) {
+	fakeBuilds(t, 100)
+	dir := t.TempDir()
+	markBuildCache(t, dir)
+	cfg := &config.Config{
+		BuildCache:    config.CacheConfig{Path: dir},
+		ProtectBuilds: true,
+		MaxDeferrals:  2,
+		StateDir:      t.TempDir(),
+	}
+	run := func(dryRun bool) *Report {
+		c := New(cfg, dryRun, false)
+		c.SetOutput(io.Discard)
+		if err := c.Run(); err != nil {
+			t.Fatal(err)
+		}
+		return c.Report()
+	}
+
+	for i, want := range []int{1, 2} {
+		if r := run(false); r.Skipped == "" || r.Deferrals != want {
+			t.Fatalf("run %d: skipped %q after %d deferrals, want a skip after %d", i+1, r.Skipped, r.Deferrals, want)
+		}
+	}
+	if r := run(true); r.Skipped != "" {
+		t.Errorf("dry run skipped (%q), want it to show the clean max_deferrals forces", r.Skipped)
+	}
+	if d := loadDeferrals(cfg); d.Count != 2 {
+		t.Errorf("%d deferrals remembered after a dry run, want it to leave 2", d.Count)
+	}
+	if r := run(false); r.Skipped != "" {
+		t.Errorf("third run skipped (%q), want it to clean after max_deferrals", r.Skipped)
+	}
+	if d := loadDeferrals(cfg); d.Count != 0 {
+		t.Errorf("%d deferrals remembered after cleaning, want 0", d.Count)
+	}
+	if r := run(false); r.Deferrals != 1 {
+		t.Errorf("run after cleaning: %d deferrals, want the count to start over", r.Deferrals)
+	}
+}
@@ -1,15 +1,15 @@
 package cleaner
 
 import (
-	"io/fs"
 	"os"
-	"path/filepath"
 	"time"
+
+	"github.com/YakDriver/cachegoat/internal/config"
 )
 
 // warmMaxIdle is how long a cache file may go untouched before keep-warm
-// refreshes it. macOS deletes files under /tmp once their atime, mtime, and
-// ctime are all older than 3 days, so refreshing at 1 day leaves two full days
+// refreshes it. macOS's temp cleaner deletes files once their atime, mtime,
+// and ctime are all older than 3 days, so refreshing at 1 day leaves two full days
 // of margin before the daily cleaner runs.
 const warmMaxIdle = 24 * time.Hour
 
@@ -24,47 +24,58 @@ const warmMaxIdle = 24 * time.Hour
 // keeps the OS cleaner from considering the file stale. Files touched recently
 // by builds are left alone, keeping the cost proportional to the number of
 // at-risk files rather than the whole cache.
+//
+// The new access time is a whole-second stamp recorded in the cache's usage
+// ledger, so age-based expiry can tell it apart from a real read. In a module
+// cache, the real access time about to be overwritten is folded into the
+// ledger first.
+//
+// It works from this run's scan of the cache, so files the cleanup just evicted
+// are skipped and the tree is not walked a second time.
 func (c *Cleaner) keepWarm(path string) (touched, scanned int) {
-	if path == "" {
+	if path == "" || !c.safeToClean(path) {
+		return 0, 0
+	}
+
+	// A missing or unreadable cache path is worth reporting, not logging as a
+	// successful no-op.
+	s := c.scan(path)
+	if s.err != nil {
+		c.logf("keep-warm: skipped %s (%v)", path, s.err)
+		c.cacheReport(path).Skipped = s.err.Error()
 		return 0, 0
 	}
 
 	now := time.Now()
 	cutoff := now.Add(-warmMaxIdle)
-	var rootErr error
+	u := c.usage(path)
+	n, _ := c.named(path)
+	isModCache := n.Kind == config.KindMod
+	var stamp time.Time
 
-	_ = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
-		if err != nil {
-			if p == path {
-				rootErr = err // the cache path itself is missing or unreadable
-			}
-			return nil // skip unreadable entries, keep scanning the rest
-		}
-		if d.IsDir() {
-			return nil
-		}
-		info, err := d.Info()
-		if err != nil {
-			return nil
-		}
+	for _, f := range s.live() {
 		scanned++
-		if fileATime(info).After(cutoff) {
-			return nil // accessed recently, not at risk
+		if f.atime.After(cutoff) {
+			continue // accessed recently, not at risk
 		}
 		if c.dryRun {
 			touched++
-			return nil
+			continue
 		}
-		// Advance the access time to now; preserve the modification time.
-		if err := os.Chtimes(p, now, info.ModTime()); err == nil {
+		if isModCache {
+			if key, ok := modVersionKey(path, f.path); ok {
+				u.observe(key, u.realUse(f.atime, f.mtime))
+			}
+		}
+		if stamp.IsZero() {
+			stamp = u.stamp(now)
+		}
+		// Advance the access time to now. The zero modification time leaves
+		// it as is, even if a build bumped it since the scan.
+		if err := os.Chtimes(f.path, stamp, time.Time{}); err == nil {
+			f.atime = stamp
 			touched++
 		}
-		return nil
-	})
-
-	if rootErr != nil {
-		c.logf("keep-warm: skipped %s (%v)", path, rootErr)
-		return touched, scanned
 	}
 
 	verb := "warmed"
@@ -72,5 +83,7 @@ func (c *Cleaner) keepWarm(path string) (touched, scanned int) {
 		verb = "would warm"
 	}
 	c.logf("keep-warm: %s %d of %d files in %s", verb, touched, scanned, path)
+	cr := c.cacheReport(path)
+	cr.Warmed, cr.WarmScanned = touched, scanned
 	return touched, scanned
 }
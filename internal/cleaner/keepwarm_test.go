@@ -114,6 +114,27 @@ func TestKeepWarmDryRun(t *testing.T) {
 	}
 }
 
+// TestKeepWarmOutsideRelocateTo verifies keep-warm warms a cache wherever it
+// lives, not just on the relocation target or in the temp directory.
+func TestKeepWarmOutsideRelocateTo(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("TMPDIR", filepath.Join(root, "tmp"))
+	cache := filepath.Join(root, "scratch", "go-cache")
+	if err := os.MkdirAll(cache, 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldTime := time.Now().Add(-5 * 24 * time.Hour)
+	writeFileAged(t, filepath.Join(cache, "idle.bin"), 0644, oldTime, oldTime)
+
+	c := New(&config.Config{RelocateTo: filepath.Join(root, "elsewhere")}, false, false)
+	if touched, scanned := c.keepWarm(cache); touched != 1 || scanned != 1 {
+		t.Errorf("outside relocate_to: touched=%d scanned=%d, want 1/1", touched, scanned)
+	}
+	if s := c.cacheReport(cache).Skipped; s != "" {
+		t.Errorf("cache reported as skipped: %q", s)
+	}
+}
+
 func TestKeepWarmEmptyPath(t *testing.T) {
 	c := New(&config.Config{}, false, false)
 	if touched, scanned := c.keepWarm(""); touched != 0 || scanned != 0 {
@@ -149,6 +170,7 @@ func TestKeepWarmMissingPathLogged(t *testing.T) {
 // size threshold (no purge), idle files are kept warm.
 func TestRunKeepsWarm(t *testing.T) {
 	tmp := t.TempDir()
+	markBuildCache(t, tmp)
 	f := filepath.Join(tmp, "idle.bin")
 	oldTime := time.Now().Add(-5 * 24 * time.Hour)
 	writeFileAged(t, f, 0644, oldTime, oldTime)
@@ -172,10 +194,11 @@ func TestRunKeepsWarm(t *testing.T) {
 // files when a build is active (only the destructive purge is skipped).
 func TestRunKeepsWarmDuringActiveBuild(t *testing.T) {
 	orig := goBuildActive
-	goBuildActive = func() bool { return true }
+	goBuildActive = func([]string) *Blocker { return &Blocker{PID: 42, Command: "go test", Args: "go test ./..."} }
 	t.Cleanup(func() { goBuildActive = orig })
 
 	tmp := t.TempDir()
+	markBuildCache(t, tmp)
 	f := filepath.Join(tmp, "idle.bin")
 	oldTime := time.Now().Add(-5 * 24 * time.Hour)
 	writeFileAged(t, f, 0644, oldTime, oldTime)
@@ -193,10 +216,14 @@ func TestRunKeepsWarmDuringActiveBuild(t *testing.T) {
 	if got := atimeOf(t, f); time.Since(got) > time.Minute {
 		t.Errorf("keep-warm did not run during active build: %v", got)
 	}
+	if b := c.Report().BlockedBy; b == nil || b.PID != 42 {
+		t.Errorf("report blocked_by = %+v, want PID 42", b)
+	}
 }
 
 func TestRunKeepWarmDisabled(t *testing.T) {
 	tmp := t.TempDir()
+	markBuildCache(t, tmp)
 	f := filepath.Join(tmp, "idle.bin")
 	oldTime := time.Now().Add(-5 * 24 * time.Hour)
 	writeFileAged(t, f, 0644, oldTime, oldTime)
@@ -0,0 +1,160 @@
+package cleaner
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+// cacheLock is a held lock on a cache, which keeps other cachegoat runs, a
+// scheduled one and a manual one, say, from cleaning or warming it at the
+// same time. It's an advisory lock (flock) on a file in the state directory,
+// so the kernel releases it if its holder dies.
+type cacheLock struct {
+	f *os.File
+}
+
+func (l *cacheLock) release() {
+	unlockFile(l.f)
+	_ = l.f.Close()
+}
+
+// lockHeldError is why a cache another run holds the lock on was skipped.
+type lockHeldError struct {
+	pid int // the holder's, if known
+}
+
+func (e *lockHeldError) Error() string {
+	if e.pid > 0 {
+		return fmt.Sprintf("locked by another cachegoat run (PID %d)", e.pid)
+	}
+	return "locked by another cachegoat run"
+}
+
+func lockFile(stateDir, path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(stateDir, "locks", hex.EncodeToString(sum[:8])+".lock")
+}
+
+// lockCache takes the lock in file, waiting up to timeout, polling at
+// lengthening intervals, for another run to let go of it. The holder records
+// its PID and host in the file. A lock still held once its holder on this
+// host is gone, as by a process it started that inherited the lock, is stale:
+// the file is replaced, leaving the lock behind with the old one.
+func lockCache(file string, timeout time.Duration) (*cacheLock, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	deadline := time.Now().Add(timeout)
+	for poll := 50 * time.Millisecond; ; poll = min(2*poll, time.Second) {
+		f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		locked, err := flockFile(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		if locked {
+			if !sameFile(f, file) {
+				// A stale lock's file was replaced while we waited for it.
+				unlockFile(f)
+				_ = f.Close()
+				continue
+			}
+			_ = f.Truncate(0)
+			_, _ = f.WriteAt([]byte(fmt.Sprintf("%d %s\n", os.Getpid(), host)), 0)
+			return &cacheLock{f: f}, nil
+		}
+
+		pid, holderHost := lockHolder(f)
+		if pid > 0 && holderHost == host && !processAlive(pid) && sameFile(f, file) {
+			_ = os.Remove(file)
+			_ = f.Close()
+			continue
+		}
+		_ = f.Close()
+		if !time.Now().Before(deadline) {
+			return nil, &lockHeldError{pid: pid}
+		}
+		time.Sleep(min(poll, time.Until(deadline)))
+	}
+}
+
+// lockHolder returns the PID and host the holder of the lock in f recorded,
+// or 0 if it hasn't yet.
+func lockHolder(f *os.File) (pid int, host string) {
+	buf := make([]byte, 256)
+	n, _ := f.ReadAt(buf, 0)
+	fields := strings.Fields(string(buf[:n]))
+	if len(fields) == 0 {
+		return 0, ""
+	}
+	pid, _ = strconv.Atoi(fields[0])
+	if len(fields) > 1 {
+		host = fields[1]
+	}
+	return pid, host
+}
+
+// sameFile reports whether f is still the file at path.
+func sameFile(f *os.File, path string) bool {
+	a, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat(path)
+	return err == nil && os.SameFile(a, b)
+}
+
+// whileLocked is called with the caches a run has just locked, before it
+// touches them. It is a var so tests can check that no two runs ever hold
+// the same cache at once.
+var whileLocked = func([]config.NamedCache) {}
+
+// lockCaches takes the lock on each of caches, in path order so two runs
+// can't each hold a lock the other waits for, and returns the caches this run
+// may go on with and the locks to release once it's done. A cache another run
+// holds for longer than the lock timeout is skipped. Without a state
+// directory, or in a dry run, which only reads, nothing is locked.
+func (c *Cleaner) lockCaches(caches []config.NamedCache) ([]config.NamedCache, []*cacheLock) {
+	if c.cfg.StateDir == "" || c.dryRun {
+		return caches, nil
+	}
+	var locks []*cacheLock
+	skipped := make(map[string]bool)
+	for _, n := range slices.SortedFunc(slices.Values(caches), func(a, b config.NamedCache) int { return cmp.Compare(a.Path, b.Path) }) {
+		if n.Path == "" {
+			continue
+		}
+		l, err := lockCache(lockFile(c.cfg.StateDir, n.Path), c.lockTimeout)
+		var held *lockHeldError
+		switch {
+		case errors.As(err, &held):
+			c.logf("%s cache: %s is %v, skipping it (see --lock-timeout)", n.Name, n.Path, err)
+			c.cacheReport(n.Path).Skipped = err.Error()
+			skipped[n.Path] = true
+		case err != nil:
+			// Coordination is best effort: a state directory that can't
+			// hold locks mustn't stop cleanup altogether.
+			c.failf(n.Path, "locking %s: %v", n.Path, err)
+		default:
+			locks = append(locks, l)
+			// Another run may have changed the ledger before letting go.
+			delete(c.ledgers, n.Path)
+		}
+	}
+	return slices.DeleteFunc(slices.Clone(caches), func(n config.NamedCache) bool { return skipped[n.Path] }), locks
+}
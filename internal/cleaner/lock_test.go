@@ -0,0 +1,174 @@
+//go:build darwin || linux
+
+package cleaner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+func TestLockCacheExcludes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "locks", "cache.lock")
+	var inside atomic.Int32
+	var overlapped atomic.Bool
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := lockCache(file, 10*time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if inside.Add(1) > 1 {
+				overlapped.Store(true)
+			}
+			time.Sleep(5 * time.Millisecond)
+			inside.Add(-1)
+			l.release()
+		}()
+	}
+	wg.Wait()
+	if overlapped.Load() {
+		t.Error("two goroutines held the lock at once")
+	}
+}
+
+func TestLockCacheHeld(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.lock")
+	l, err := lockCache(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lockCache(file, 0)
+	if want := fmt.Sprintf("locked by another cachegoat run (PID %d)", os.Getpid()); err == nil || err.Error() != want {
+		t.Fatalf("second lock: %v, want %q", err, want)
+	}
+
+	// A waiting run gets the lock once it's released.
+	time.AfterFunc(100*time.Millisecond, l.release)
+	l2, err := lockCache(file, 10*time.Second)
+	if err != nil {
+		t.Fatalf("waiting for the lock: %v", err)
+	}
+	l2.release()
+}
+
+func TestLockCacheStale(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.lock")
+	l, err := lockCache(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.release()
+
+	// Still locked, but by a process that's gone.
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	host, _ := os.Hostname()
+	_ = l.f.Truncate(0)
+	if _, err := l.f.WriteAt([]byte(fmt.Sprintf("%d %s\n", cmd.Process.Pid, host)), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	l2, err := lockCache(file, 0)
+	if err != nil {
+		t.Fatalf("stale lock not recovered: %v", err)
+	}
+	defer l2.release()
+	if pid, _ := lockHolder(l2.f); pid != os.Getpid() {
+		t.Errorf("recovered lock records PID %d, want %d", pid, os.Getpid())
+	}
+}
+
+func TestConcurrentRuns(t *testing.T) {
+	dir := t.TempDir()
+	markBuildCache(t, dir)
+	for i := range 20 {
+		writeBuildEntry(t, dir, fmt.Sprintf("%02x01-d", i), 1000, time.Duration(i+1)*time.Hour)
+	}
+	cfg := &config.Config{
+		BuildCache: config.CacheConfig{Path: dir, MaxSize: config.Size{Bytes: 1}, Measure: config.MeasureApparent},
+		StateDir:   t.TempDir(),
+	}
+
+	// No two runs hold the cache at once, though each lingers while holding it.
+	var (
+		mu         sync.Mutex
+		holding    int
+		overlapped bool
+	)
+	orig := whileLocked
+	t.Cleanup(func() { whileLocked = orig })
+	whileLocked = func(caches []config.NamedCache) {
+		if !slices.ContainsFunc(caches, func(n config.NamedCache) bool { return n.Path == dir }) {
+			return
+		}
+		mu.Lock()
+		holding++
+		overlapped = overlapped || holding > 1
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		holding--
+		mu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	var removed atomic.Int32
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := New(cfg, false, false)
+			c.SetOutput(io.Discard)
+			c.SetLockTimeout(10 * time.Second)
+			if err := c.Run(); err != nil {
+				t.Error(err)
+			}
+			r := c.Report()
+			if len(r.Errors) > 0 {
+				t.Errorf("errors: %v", r.Errors)
+			}
+			for _, cr := range r.Caches {
+				removed.Add(int32(cr.Removed))
+			}
+		}()
+	}
+	wg.Wait()
+	if overlapped {
+		t.Error("two runs held the build cache at once")
+	}
+	if got := removed.Load(); got != 20 {
+		t.Errorf("runs removed %d entries between them, want each of the 20 once", got)
+	}
+
+	// With no time to wait, a run skips a cache another holds.
+	l, err := lockCache(lockFile(cfg.StateDir, dir), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.release()
+	c := New(cfg, false, false)
+	c.SetOutput(io.Discard)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if cr := c.Report().Caches; len(cr) != 1 || !strings.Contains(cr[0].Skipped, fmt.Sprintf("PID %d", os.Getpid())) {
+		t.Errorf("caches %+v, want the build cache skipped as locked by PID %d", cr, os.Getpid())
+	}
+}
@@ -0,0 +1,30 @@
+//go:build darwin || linux
+
+package cleaner
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// flockFile tries to take an exclusive lock on f, reporting false if another
+// open file holds one.
+func flockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlockFile(f *os.File) {
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// processAlive reports whether the process pid exists, even if it belongs to
+// someone else.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,534 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// Ways Migrate can move a cache, as listed in Migration.Method.
+const (
+	MethodRename = "rename" // the whole tree renamed within one filesystem
+	MethodCopy   = "copy"   // copied file by file, merging into what's there
+)
+
+// partialSuffix marks a file Migrate is still copying. Only a complete,
+// verified copy is renamed to its real name, so an interrupted migration never
+// leaves a truncated cache file for Go to trust.
+const partialSuffix = ".cachegoat-partial"
+
+// Migration is the move of one cache's contents from its old location to
+// where it was relocated.
+type Migration struct {
+	Name     string   `json:"name"` // "build" or "mod"
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Method   string   `json:"method,omitempty"`
+	Files    int      `json:"files"`              // copied this run
+	Bytes    int64    `json:"bytes"`              // copied this run
+	Present  int      `json:"present"`            // already at To, from an earlier run or Go itself
+	Verified int      `json:"verified"`           // module zips that matched their go.sum hash
+	Rejected []string `json:"rejected,omitempty"` // module versions whose zip didn't, left for Go to download again
+	Done     bool     `json:"done"`
+	Deleted  bool     `json:"deleted"` // the old location was removed
+	Error    string   `json:"error,omitempty"`
+}
+
+// MigrateOptions controls whether Migrate deletes the old locations.
+type MigrateOptions struct {
+	Yes bool      // delete old locations without asking
+	In  io.Reader // answers to y/N prompts; nil never prompts, keeping them
+	Out io.Writer // where progress goes
+}
+
+// migrationState is what Migrate remembers between runs: where each cache is
+// moving from and to, and whether the move finished. Once the shell profile
+// points Go at the new location, this is the only record of the old one.
+type migrationState struct {
+	Migrations []*Migration `json:"migrations"`
+}
+
+// migrationFile returns where the migration state lives, or "" if cfg
+// persists nothing.
+func migrationFile(cfg *config.Config) string {
+	if cfg.StateDir == "" {
+		return ""
+	}
+	return filepath.Join(cfg.StateDir, "migrate.json")
+}
+
+func loadMigrations(cfg *config.Config) []*Migration {
+	var st migrationState
+	if file := migrationFile(cfg); file != "" {
+		if data, err := os.ReadFile(file); err == nil {
+			_ = json.Unmarshal(data, &st)
+		}
+	}
+	return st.Migrations
+}
+
+// saveMigrations records the migrations still to finish or clean up, removing
+// the state file once there are none.
+func saveMigrations(cfg *config.Config, ms []*Migration) error {
+	file := migrationFile(cfg)
+	if file == "" {
+		return nil
+	}
+	var st migrationState
+	for _, m := range ms {
+		if !m.Deleted {
+			st.Migrations = append(st.Migrations, &Migration{Name: m.Name, From: m.From, To: m.To, Done: m.Done})
+		}
+	}
+	if len(st.Migrations) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeJSONAtomic(file, st)
+}
+
+// recordMigrations remembers that the caches named in moves, by name, are
+// being relocated, so a later Migrate knows where their contents are.
+func recordMigrations(cfg *config.Config, moves []*Migration) error {
+	ms := loadMigrations(cfg)
+	for _, mv := range moves {
+		found := false
+		for _, m := range ms {
+			if m.Name == mv.Name {
+				m.From, m.To, m.Done = mv.From, mv.To, false
+				found = true
+			}
+		}
+		if !found {
+			ms = append(ms, mv)
+		}
+	}
+	return saveMigrations(cfg, ms)
+}
+
+// planMigrations returns the migrations to carry out: those recorded when the
+// caches were relocated, or else, for caches relocated before cachegoat kept
+// such records, a move from Go's default location to the relocated one.
+func planMigrations(cfg *config.Config) []*Migration {
+	if ms := loadMigrations(cfg); len(ms) > 0 {
+		return ms
+	}
+	home, _ := os.UserHomeDir()
+	build, mod := goDefaultCaches()
+	var ms []*Migration
+	for _, m := range []*Migration{
+		{Name: "build", From: build, To: cfg.BuildCache.Path},
+		{Name: "mod", From: mod, To: cfg.ModCache.Path},
+	} {
+		if m.From == "" || m.To == "" || !onRelocateTarget(m.To, cfg.RelocateRoot(), home) || resolvePath(m.From) == resolvePath(m.To) {
+			continue
+		}
+		if _, err := os.Lstat(m.From); err == nil {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+// goDefaultCaches returns where Go keeps its caches when GOCACHE and
+// GOMODCACHE are unset.
+func goDefaultCaches() (build, mod string) {
+	if dir, err := os.UserCacheDir(); err == nil {
+		build = filepath.Join(dir, "go-build")
+	}
+	if out, err := exec.Command("go", "env", "GOPATH").Output(); err == nil {
+		if gopath := filepath.SplitList(strings.TrimSpace(string(out))); len(gopath) > 0 && gopath[0] != "" {
+			mod = filepath.Join(gopath[0], "pkg", "mod")
+		}
+	}
+	return build, mod
+}
+
+// goEnvCaches returns the caches the go command uses now, by go env, or ""
+// for each it can't tell.
+func goEnvCaches() (build, mod string) {
+	out, err := exec.Command("go", "env", "GOCACHE", "GOMODCACHE").Output()
+	if err != nil {
+		return "", ""
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 2 {
+		return "", ""
+	}
+	return absPath(lines[0]), absPath(lines[1])
+}
+
+// absPath returns p, trimmed, if it's an absolute path, and "" for anything
+// else, such as GOCACHE=off.
+func absPath(p string) string {
+	if p = strings.TrimSpace(p); !filepath.IsAbs(p) {
+		return ""
+	}
+	return p
+}
+
+// stillInUse reports whether Go is still configured to use m's old location,
+// as when the relocated shell profile hasn't taken effect yet.
+func stillInUse(cfg *config.Config, m *Migration) bool {
+	inUse := cfg.BuildCache.Path
+	if m.Name == "mod" {
+		inUse = cfg.ModCache.Path
+	}
+	return inUse != "" && resolvePath(inUse) == resolvePath(m.From)
+}
+
+// Migrate moves the contents of relocated caches from their old locations to
+// the new ones: a rename when the new location doesn't exist yet and is on the
+// same filesystem, or else a copy that merges into it, preserving modes and
+// times, and checking module zips against their go.sum hashes. Progress is
+// saved as it goes, so an interrupted migration picks up where it left off.
+// Once every cache has moved, it offers to delete the old locations.
+func Migrate(cfg *config.Config, opts MigrateOptions) ([]*Migration, error) {
+	w := opts.Out
+	ms := planMigrations(cfg)
+	if len(ms) == 0 {
+		_, _ = fmt.Fprintln(w, "✓ Nothing to migrate; relocate the caches first with 'cachegoat --recommend --apply=relocate'")
+		return ms, nil
+	}
+
+	var sums map[string]string
+	for _, m := range ms {
+		if m.Done {
+			_, _ = fmt.Fprintf(w, "✓ %s cache already migrated to %s\n", m.Name, m.To)
+			continue
+		}
+		if stillInUse(cfg, m) {
+			_, _ = fmt.Fprintf(w, "⚠️  Go still uses %s; restart your shell so the relocated %s cache path takes effect, then run --migrate again\n", m.From, m.Name)
+			continue
+		}
+		if m.Name == "mod" && sums == nil {
+			sums = goSumHashes(cfg)
+		}
+		_, _ = fmt.Fprintf(w, "🚚 Migrating %s cache: %s → %s\n", m.Name, m.From, m.To)
+		if err := migrateCache(m, sums); err != nil {
+			m.Error = err.Error()
+			_ = saveMigrations(cfg, ms)
+			return ms, fmt.Errorf("%s cache: %w (run --migrate again to resume)", m.Name, err)
+		}
+		m.Done = true
+		if err := saveMigrations(cfg, ms); err != nil {
+			return ms, fmt.Errorf("failed to save migration state: %w", err)
+		}
+		switch m.Method {
+		case MethodRename:
+			_, _ = fmt.Fprintf(w, "✅ Renamed %s to %s\n", m.From, m.To)
+		case MethodCopy:
+			_, _ = fmt.Fprintf(w, "✅ Copied %d files (%.1fGB); %d already present\n", m.Files, toGB(m.Bytes), m.Present)
+		default:
+			_, _ = fmt.Fprintf(w, "✓ %s no longer exists; nothing to copy\n", m.From)
+		}
+		if m.Verified > 0 || len(m.Rejected) > 0 {
+			_, _ = fmt.Fprintf(w, "   → %d module zips verified against go.sum\n", m.Verified)
+		}
+		for _, v := range m.Rejected {
+			_, _ = fmt.Fprintf(w, "⚠️  %s: zip doesn't match its go.sum hash; left for Go to download again\n", v)
+		}
+	}
+
+	for _, m := range ms {
+		if !m.Done || stillInUse(cfg, m) {
+			continue
+		}
+		if _, err := os.Lstat(m.From); os.IsNotExist(err) {
+			m.Deleted = true
+			continue
+		}
+		q := fmt.Sprintf("❓ Delete the old %s cache at %s (%.1fGB)? (y/N): ", m.Name, m.From, toGB(dirSize(m.From)))
+		if !opts.Yes && !confirm(opts.In, w, q) {
+			_, _ = fmt.Fprintf(w, "   Kept %s; run --migrate again to delete it\n", m.From)
+			continue
+		}
+		if err := removeReadOnlyTree(m.From); err != nil {
+			m.Error = err.Error()
+			_ = saveMigrations(cfg, ms)
+			return ms, fmt.Errorf("failed to delete %s: %w", m.From, err)
+		}
+		m.Deleted = true
+		_, _ = fmt.Fprintf(w, "✅ Deleted %s\n", m.From)
+	}
+	if err := saveMigrations(cfg, ms); err != nil {
+		return ms, fmt.Errorf("failed to save migration state: %w", err)
+	}
+	return ms, nil
+}
+
+// migrateCache moves m.From to m.To, renaming the whole tree if it can.
+func migrateCache(m *Migration, sums map[string]string) error {
+	if _, err := os.Lstat(m.From); os.IsNotExist(err) {
+		return nil
+	}
+	// Deleting the old location afterwards must never touch the new one.
+	if from, to := resolvePath(m.From), resolvePath(m.To); within(from, to) || within(to, from) {
+		return fmt.Errorf("%s and %s overlap", m.From, m.To)
+	}
+	if err := os.MkdirAll(filepath.Dir(m.To), 0700); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(m.To); os.IsNotExist(err) {
+		if err := os.Rename(m.From, m.To); err == nil {
+			m.Method = MethodRename
+			return nil
+		}
+		// Most likely another filesystem: fall back to copying.
+	}
+	m.Method = MethodCopy
+	return copyCache(m, sums)
+}
+
+// copyCache copies every regular file under m.From that m.To lacks, then gives
+// the directories their original modes, which for a module cache means
+// read-only. A module cache's extracted versions are copied one tree at a
+// time, each marked partial until it's complete (see copyVersionTree).
+// Directories stay writable while the copy runs, including when it resumes
+// into directories an earlier run already finished.
+func copyCache(m *Migration, sums map[string]string) error {
+	type dirMode struct {
+		path string
+		mode fs.FileMode
+	}
+	var dirs []dirMode
+	rejected := make(map[string]bool) // escaped "path/@v/version"
+	inVersion := false
+
+	var visit fs.WalkDirFunc
+	visit = func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(m.From, src)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(m.To, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && m.Name == "mod" && !inVersion && src != m.From {
+			if modPath, ver, ok := strings.Cut(filepath.ToSlash(rel), "@"); ok && !within(src, filepath.Join(m.From, "cache")) {
+				inVersion = true
+				err := copyVersionTree(m, modPath, ver, func() error { return filepath.WalkDir(src, visit) })
+				inVersion = false
+				if err != nil {
+					return err
+				}
+				return fs.SkipDir
+			}
+		}
+		if d.IsDir() {
+			if err := os.MkdirAll(dst, 0700); err != nil {
+				return err
+			}
+			if err := os.Chmod(dst, info.Mode().Perm()|0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMode{dst, info.Mode().Perm()})
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(src, partialSuffix) {
+			return nil
+		}
+		if _, err := os.Lstat(dst); err == nil {
+			m.Present++
+			return nil
+		}
+
+		zipKey, isZip := "", false
+		if m.Name == "mod" {
+			if key, ok := strings.CutSuffix(filepath.ToSlash(rel), ".zip"); ok && strings.HasPrefix(key, "cache/download/") {
+				zipKey, isZip = key, true
+			}
+			if key, ok := strings.CutSuffix(filepath.ToSlash(rel), ".ziphash"); ok && rejected[key] {
+				return nil // dropped with its zip, so Go fetches both again
+			}
+		}
+
+		tmp := dst + partialSuffix
+		if err := copyFile(src, tmp); err != nil {
+			return err
+		}
+		if isZip {
+			result, err := verifyModZip(tmp, src, zipKey, sums)
+			if err != nil {
+				_ = os.Remove(tmp)
+				return err
+			}
+			switch result {
+			case verifyMismatch:
+				_ = os.Remove(tmp)
+				rejected[zipKey] = true
+				m.Rejected = append(m.Rejected, zipModVersion(zipKey))
+				return nil
+			case verifyMatch:
+				m.Verified++
+			}
+		}
+		if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(tmp, fileATime(info), info.ModTime()); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, dst); err != nil {
+			return err
+		}
+		m.Files++
+		m.Bytes += info.Size()
+		return nil
+	}
+	if err := filepath.WalkDir(m.From, visit); err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if err := os.Chmod(d.path, d.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyVersionTree runs copyTree, which copies the extracted tree of module
+// version modPath@ver, with the version's .partial marker in place in the new
+// cache. Go trusts an extracted tree once its .ziphash exists and no marker
+// does, so a build running meanwhile, or after an interruption, extracts the
+// version again rather than use a half-copied tree. The marker stays if the
+// old cache had one too.
+func copyVersionTree(m *Migration, modPath, ver string, copyTree func() error) error {
+	rel := filepath.Join("cache", "download", filepath.FromSlash(modPath), "@v", ver+".partial")
+	partial := filepath.Join(m.To, rel)
+	if err := os.MkdirAll(filepath.Dir(partial), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(partial, nil, 0644); err != nil {
+		return err
+	}
+	if err := copyTree(); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(filepath.Join(m.From, rel)); err == nil {
+		return nil
+	}
+	return os.Remove(partial)
+}
+
+// copyFile copies the contents of src to a new file dst, replacing any
+// leftover from an interrupted copy.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	_ = os.Remove(dst)
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Outcomes of checking a module zip's hash.
+const (
+	verifyNone     = iota // nothing to check it against
+	verifyMatch           // it matched
+	verifyMismatch        // it didn't
+)
+
+// verifyModZip hashes the copied module zip tmp the way go.sum does and checks
+// it against the hash Go recorded beside the original, src, and against any
+// go.sum in the protected workspaces listing that version. key is the zip's
+// path relative to the cache, without its extension.
+func verifyModZip(tmp, src, key string, sums map[string]string) (int, error) {
+	var want []string
+	if data, err := os.ReadFile(strings.TrimSuffix(src, ".zip") + ".ziphash"); err == nil {
+		want = append(want, strings.TrimSpace(string(data)))
+	}
+	if h, ok := sums[zipModVersion(key)]; ok {
+		want = append(want, h)
+	}
+	if len(want) == 0 {
+		return verifyNone, nil
+	}
+	got, err := dirhash.HashZip(tmp, dirhash.Hash1)
+	if err != nil {
+		// A zip that can't even be read is as corrupt as one that hashes wrong.
+		var ferr *fs.PathError
+		if errors.As(err, &ferr) {
+			return verifyNone, err
+		}
+		return verifyMismatch, nil
+	}
+	for _, h := range want {
+		if h != got {
+			return verifyMismatch, nil
+		}
+	}
+	return verifyMatch, nil
+}
+
+// zipModVersion turns a download path key such as
+// "cache/download/github.com/!foo/bar/@v/v1.0.0" into "github.com/Foo/bar@v1.0.0",
+// or returns it unchanged if it doesn't parse.
+func zipModVersion(key string) string {
+	escPath, escVer, ok := strings.Cut(strings.TrimPrefix(key, "cache/download/"), "/@v/")
+	if !ok {
+		return key
+	}
+	path, err := module.UnescapePath(escPath)
+	if err != nil {
+		return key
+	}
+	version, err := module.UnescapeVersion(escVer)
+	if err != nil {
+		return key
+	}
+	return path + "@" + version
+}
+
+// goSumHashes collects the module zip hashes recorded in the go.sum files of
+// the protected workspaces and keep_referenced, by "path@version".
+func goSumHashes(cfg *config.Config) map[string]string {
+	sums := make(map[string]string)
+	add := func(file string) {
+		base := filepath.Base(file)
+		if base != "go.sum" && base != "go.work.sum" {
+			return
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return
+		}
+		for line := range strings.SplitSeq(string(data), "\n") {
+			// "<path> <version> <hash>"; "/go.mod" lines hash just the go.mod.
+			if f := strings.Fields(line); len(f) == 3 && !strings.HasSuffix(f[1], "/go.mod") {
+				sums[f[0]+"@"+f[1]] = f[2]
+			}
+		}
+	}
+	for _, f := range cfg.ModCache.KeepReferenced {
+		add(f)
+	}
+	walkGoFiles(cfg.ProtectWorkspaces, add)
+	return sums
+}
@@ -0,0 +1,183 @@
+//go:build darwin || linux
+
+package cleaner
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// writeModZip writes a module zip for path@version into the download cache
+// under root, with a .ziphash holding its real hash, or a wrong one if
+// corrupt is set.
+func writeModZip(t *testing.T, root, path, version string, corrupt bool) {
+	t.Helper()
+	vdir := filepath.Join(root, "cache", "download", path, "@v")
+	if err := os.MkdirAll(vdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(vdir, version+".zip")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create(path + "@" + version + "/go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.WriteString(w, "module "+path+"\n")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	hash, err := dirhash.HashZip(file, dirhash.Hash1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if corrupt {
+		hash = "h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	}
+	if err := os.WriteFile(filepath.Join(vdir, version+".ziphash"), []byte(hash), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateCopiesVerifiesAndResumes(t *testing.T) {
+	root := t.TempDir()
+	from, to := filepath.Join(root, "old"), filepath.Join(root, "new")
+	writeModVersion(t, from, "example.com/a", "v1.0.0", 10, 0)
+	writeModZip(t, from, "example.com/a", "v1.0.0", false)
+	writeModZip(t, from, "example.com/good", "v1.0.0", false)
+	writeModZip(t, from, "example.com/bad", "v1.0.0", true)
+	// Go already started filling the new location, so a rename can't work.
+	if err := os.MkdirAll(filepath.Join(to, "cache"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = removeReadOnlyTree(to) })
+
+	cfg := &config.Config{StateDir: t.TempDir()}
+	if err := recordMigrations(cfg, []*Migration{{Name: "mod", From: from, To: to}}); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := Migrate(cfg, MigrateOptions{Out: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := ms[0]
+	if m.Method != MethodCopy || !m.Done || m.Deleted {
+		t.Fatalf("method=%s done=%v deleted=%v, want a finished copy that kept the old cache", m.Method, m.Done, m.Deleted)
+	}
+	if m.Verified != 2 || len(m.Rejected) != 1 || m.Rejected[0] != "example.com/bad@v1.0.0" {
+		t.Errorf("verified=%d rejected=%v", m.Verified, m.Rejected)
+	}
+	bad := filepath.Join(to, "cache", "download", "example.com", "bad", "@v", "v1.0.0")
+	if exists(bad+".zip") || exists(bad+".ziphash") {
+		t.Error("a zip that fails verification should not be migrated, nor its hash")
+	}
+	if exists(filepath.Join(to, "cache", "download", "example.com", "a", "@v", "v1.0.0.partial")) {
+		t.Error("a completely copied version should not be left marked partial")
+	}
+	for p, want := range map[string]os.FileMode{"a@v1.0.0": 0555, "a@v1.0.0/go.mod": 0444} {
+		info, err := os.Stat(filepath.Join(to, "example.com", filepath.FromSlash(p)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("%s mode = %v, want %v", p, info.Mode().Perm(), want)
+		}
+	}
+
+	// Resuming, say after an interruption, skips what's already there, and
+	// deletes the old location once allowed to.
+	m.Done = false
+	if err := saveMigrations(cfg, ms); err != nil {
+		t.Fatal(err)
+	}
+	ms, err = Migrate(cfg, MigrateOptions{Yes: true, Out: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := ms[0]; m.Files != 0 || m.Present == 0 || !m.Deleted {
+		t.Errorf("resume: files=%d present=%d deleted=%v", m.Files, m.Present, m.Deleted)
+	}
+	if exists(from) {
+		t.Error("old location should be deleted")
+	}
+	if _, err := os.Stat(migrationFile(cfg)); !os.IsNotExist(err) {
+		t.Errorf("migration state should be gone once everything moved: %v", err)
+	}
+}
+
+// TestCopyVersionTreeMarksPartial covers a copy interrupted partway through a
+// version's tree: Go must find the version marked partial, and extract it
+// again, rather than trust what was copied.
+func TestCopyVersionTreeMarksPartial(t *testing.T) {
+	root := t.TempDir()
+	m := &Migration{Name: "mod", From: filepath.Join(root, "old"), To: filepath.Join(root, "new")}
+	partial := filepath.Join(m.To, "cache", "download", "example.com", "a", "@v", "v1.0.0.partial")
+
+	interrupted := errors.New("interrupted")
+	err := copyVersionTree(m, "example.com/a", "v1.0.0", func() error {
+		if !exists(partial) {
+			t.Error("version not marked partial while its tree is copied")
+		}
+		return interrupted
+	})
+	if err != interrupted || !exists(partial) {
+		t.Errorf("interrupted copy: err=%v, marked partial=%v; want the marker left", err, exists(partial))
+	}
+
+	if err := copyVersionTree(m, "example.com/a", "v1.0.0", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if exists(partial) {
+		t.Error("completed copy left the version marked partial")
+	}
+}
+
+// TestMigrateRenamesFromGoDefault covers caches relocated before migrations
+// were recorded: the old contents are found at Go's default location.
+func TestMigrateRenamesFromGoDefault(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("HOME", root)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(root, ".cache"))
+	t.Setenv("GOPATH", filepath.Join(root, "go"))
+	from, _ := goDefaultCaches()
+	writeBuildEntry(t, from, "aa01-d", 100, 0)
+
+	to := filepath.Join(root, "scratch", "me", "go-cache")
+	cfg := &config.Config{BuildCache: config.CacheConfig{Path: to}, RelocateTo: filepath.Join(root, "scratch")}
+	ms, err := Migrate(cfg, MigrateOptions{Out: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 1 || ms[0].From != from || ms[0].Method != MethodRename {
+		t.Fatalf("got %+v, want one rename from %s", ms, from)
+	}
+	if exists(from) || !exists(filepath.Join(to, "aa", "aa01-d")) {
+		t.Error("the build cache should have moved")
+	}
+}
+
+func TestMigrateRefusesOverlap(t *testing.T) {
+	root := t.TempDir()
+	cfg := &config.Config{StateDir: t.TempDir()}
+	if err := recordMigrations(cfg, []*Migration{{Name: "build", From: root, To: filepath.Join(root, "inner")}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(cfg, MigrateOptions{Yes: true, Out: io.Discard}); err == nil {
+		t.Error("expected an error migrating a cache into itself")
+	}
+	if !exists(root) {
+		t.Error("nothing should be deleted")
+	}
+}
@@ -0,0 +1,402 @@
+package cleaner
+
+import (
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// downloadExts are the per-version files Go keeps under
+// cache/download/<module>/@v/. A version's ".partial" marker is handled
+// separately by evictModVersion.
+var downloadExts = []string{".ziphash", ".zip", ".mod", ".info", ".lock"}
+
+// vcsDir is where Go clones the repositories of modules it fetches straight
+// from version control: cache/vcs/<hash>, next to a <hash>.info file naming
+// the remote and a <hash>.lock file Go locks while it uses the clone.
+const vcsDir = "cache/vcs"
+
+// modVersion is one module version in the module cache: its extracted source
+// tree (<module>@<version>) together with its download files
+// (cache/download/<module>/@v/<version>.*). Paths and versions are kept in
+// Go's case-encoded on-disk form; Path and Version decode them for display.
+//
+// A VCS clone is evicted the same way, as an entry of its own: its escPath is
+// vcsDir, its escVer the clone's hash, and its .info file its only download
+// file. It's never a valid semantic version, so keep_versions leaves it be.
+type modVersion struct {
+	escPath string
+	escVer  string
+	dir     string      // extracted source tree, "" if not extracted
+	files   []string    // download files
+	scanned []*scanFile // every file of the version, tree and download
+	size    int64
+	lastUse time.Time
+}
+
+func (v *modVersion) Path() string {
+	if p, err := module.UnescapePath(v.escPath); err == nil {
+		return p
+	}
+	return v.escPath
+}
+
+func (v *modVersion) Version() string {
+	if s, err := module.UnescapeVersion(v.escVer); err == nil {
+		return s
+	}
+	return v.escVer
+}
+
+func (v *modVersion) String() string {
+	if v.isClone() {
+		return "VCS clone " + v.escVer
+	}
+	return v.Path() + "@" + v.Version()
+}
+
+func (v *modVersion) isClone() bool { return v.escPath == vcsDir }
+
+// add accounts a file belonging to this version towards its size and recency.
+func (v *modVersion) add(f *scanFile, u *usageLedger) {
+	v.scanned = append(v.scanned, f)
+	v.size += f.size
+	if t := u.realUse(f.atime, f.mtime); t.After(v.lastUse) {
+		v.lastUse = t
+	}
+}
+
+// modCacheVersions groups a scan of a module cache into module versions,
+// least recently used first. A version's last use is the latest real use of
+// any of its files, as tracked by the usage ledger u (which may be nil). VCS
+// clones are listed alongside. Everything else — cache/download/sumdb, the
+// per-module "list" files, the clones' locks — is ignored: it counts towards
+// the cache's size but is never evicted.
+func modCacheVersions(s *cacheScan, u *usageLedger) []*modVersion {
+	versions := make(map[string]*modVersion)
+	for _, f := range s.live() {
+		escPath, escVer, download, ok := splitModCachePath(s.root, f.path)
+		if !ok {
+			continue
+		}
+		key := escPath + "@" + escVer
+		v, ok := versions[key]
+		if !ok {
+			v = &modVersion{escPath: escPath, escVer: escVer}
+			versions[key] = v
+		}
+		if download {
+			v.files = append(v.files, f.path)
+		} else if v.isClone() {
+			v.dir = filepath.Join(s.root, filepath.FromSlash(vcsDir), escVer)
+		} else {
+			v.dir = filepath.Join(s.root, filepath.FromSlash(key))
+		}
+		v.add(f, u)
+	}
+
+	out := make([]*modVersion, 0, len(versions))
+	seen := make(map[string]bool, len(versions))
+	for _, v := range versions {
+		v.lastUse = u.observe(v.String(), v.lastUse)
+		seen[v.String()] = true
+		out = append(out, v)
+	}
+	u.retain(seen)
+	slices.SortFunc(out, func(a, b *modVersion) int {
+		if c := a.lastUse.Compare(b.lastUse); c != 0 {
+			return c
+		}
+		return strings.Compare(a.String(), b.String())
+	})
+	return out
+}
+
+// splitModCachePath returns the (escaped) module path and version that the
+// file p under the module cache at root belongs to, and whether it is one of
+// the version's download files rather than part of its extracted tree. For a
+// file of a VCS clone, they are vcsDir and the clone's hash.
+func splitModCachePath(root, p string) (escPath, escVer string, download, ok bool) {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return "", "", false, false
+	}
+	rel = filepath.ToSlash(rel)
+
+	switch {
+	case strings.HasPrefix(rel, "cache/download/"):
+		var file string
+		if escPath, file, ok = strings.Cut(strings.TrimPrefix(rel, "cache/download/"), "/@v/"); !ok || strings.Contains(file, "/") {
+			return "", "", false, false
+		}
+		if escVer, ok = trimDownloadExt(file); !ok {
+			return "", "", false, false
+		}
+		download = true
+	case strings.HasPrefix(rel, vcsDir+"/"):
+		hash, _, inTree := strings.Cut(strings.TrimPrefix(rel, vcsDir+"/"), "/")
+		if !inTree {
+			// The clone's .info file; its .lock file is Go's to keep.
+			if hash, ok = strings.CutSuffix(hash, ".info"); !ok {
+				return "", "", false, false
+			}
+		}
+		if hash == "" {
+			return "", "", false, false
+		}
+		return vcsDir, hash, !inTree, true
+	case strings.HasPrefix(rel, "cache/"):
+		return "", "", false, false // sumdb tiles: not a module version
+	default:
+		var rest string
+		if escPath, rest, ok = strings.Cut(rel, "@"); !ok {
+			return "", "", false, false
+		}
+		if escVer, _, ok = strings.Cut(rest, "/"); !ok {
+			return "", "", false, false // a file, not a tree, named like a version
+		}
+	}
+
+	if _, err := module.UnescapeVersion(escVer); err != nil {
+		return "", "", false, false
+	}
+	return escPath, escVer, download, true
+}
+
+// trimDownloadExt strips a known download-file extension from name, returning
+// the (escaped) version it belongs to.
+func trimDownloadExt(name string) (string, bool) {
+	for _, ext := range downloadExts {
+		if v, ok := strings.CutSuffix(name, ext); ok && v != "" {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// evictModVersion removes a module version from the module cache at root
+// without ever exposing a half-deleted <module>@<version> tree to Go.
+//
+// Go treats an extracted directory as complete unless a
+// cache/download/<module>/@v/<version>.partial marker exists, in which case it
+// discards the directory and extracts it again. Eviction writes that marker
+// first, so if it is interrupted at any point the next build simply
+// re-downloads the module instead of failing on missing files. The marker is
+// removed last, once nothing of the version remains.
+func evictModVersion(root string, v *modVersion) error {
+	if v.isClone() {
+		return evictClone(root, v)
+	}
+	vdir := filepath.Join(root, "cache", "download", filepath.FromSlash(v.escPath), "@v")
+	partial := filepath.Join(vdir, v.escVer+".partial")
+
+	if v.dir != "" {
+		if err := os.MkdirAll(vdir, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(partial, nil, 0644); err != nil {
+			return err
+		}
+		if err := removeReadOnlyTree(v.dir); err != nil {
+			return err
+		}
+	}
+	for _, f := range v.files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Remove(partial); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, f := range v.scanned {
+		f.removed = true
+	}
+	return nil
+}
+
+// evictClone removes a VCS clone from the module cache at root. Go reuses a
+// clone only while both it and its .info file exist, and clones afresh
+// otherwise, so the .info file goes first. The clone's lock is held
+// throughout, and a clone Go has locked is left alone. The lock file stays,
+// as Go may be waiting on it.
+func evictClone(root string, v *modVersion) error {
+	f, err := os.OpenFile(filepath.Join(root, filepath.FromSlash(vcsDir), v.escVer+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	locked, err := flockFile(f)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return errors.New("in use by the go command")
+	}
+	defer unlockFile(f)
+
+	for _, f := range v.files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if v.dir != "" {
+		if err := removeReadOnlyTree(v.dir); err != nil {
+			return err
+		}
+	}
+	for _, f := range v.scanned {
+		f.removed = true
+	}
+	return nil
+}
+
+// removeReadOnlyTree deletes a directory tree that Go extracted read-only.
+// Directories must be made writable first, or their entries can't be
+// unlinked.
+func removeReadOnlyTree(dir string) error {
+	_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				_ = os.Chmod(p, info.Mode().Perm()|0700)
+			}
+		}
+		return nil
+	})
+	return os.RemoveAll(dir)
+}
+
+// trimModCache evicts least-recently-used module versions from the module
+// cache at root until its size (currently size bytes) is at or below target
+// bytes. versions must be ordered least recently used first, as
+// modCacheVersions returns them. Pinned versions are skipped. Each eviction,
+// and each pinned version spared, is logged by module version. It returns the
+// number of versions removed and the bytes reclaimed; in dry-run mode nothing
+// is deleted, but the counts reflect what would be.
+func (c *Cleaner) trimModCache(root string, versions []*modVersion, pins pinSet, size, target int64) (removed int, reclaimed int64) {
+	verb := "evicted"
+	if c.dryRun {
+		verb = "would evict"
+	}
+	for _, v := range versions {
+		if size-reclaimed <= target {
+			break
+		}
+		if src, ok := pins.pinned(v); ok {
+			c.logf("mod cache: keeping %s (pinned by %s)", v, src)
+			continue
+		}
+		if !c.dryRun {
+			if err := evictModVersion(root, v); err != nil {
+				c.failf(root, "mod cache: failed to evict %s: %v", v, err)
+				continue
+			}
+		}
+		c.logf("mod cache: %s %s (%.1fMB, last used %s)", verb, v, toMB(v.size), v.lastUse.Format(time.DateOnly))
+		removed++
+		reclaimed += v.size
+	}
+	return removed, reclaimed
+}
+
+// pruneModVersions enforces keep_versions: each module keeps its newest keep
+// versions by semantic version, plus any pinned version, and the rest are
+// evicted. Versions that are not valid semver are never pruned. Every module
+// with something to prune is logged with the versions and bytes reclaimed. It
+// returns the surviving versions, in their original order, and the bytes
+// reclaimed.
+func (c *Cleaner) pruneModVersions(root string, versions []*modVersion, keep int, pins pinSet) (survivors []*modVersion, reclaimed int64) {
+	verb := "pruned"
+	if c.dryRun {
+		verb = "would prune"
+	}
+
+	byModule := make(map[string][]*modVersion)
+	for _, v := range versions {
+		if semver.IsValid(v.Version()) {
+			byModule[v.Path()] = append(byModule[v.Path()], v)
+		}
+	}
+
+	removed := make(map[*modVersion]bool)
+	for _, path := range slices.Sorted(maps.Keys(byModule)) {
+		mvs := byModule[path]
+		if len(mvs) <= keep {
+			continue
+		}
+		slices.SortFunc(mvs, func(a, b *modVersion) int {
+			return semver.Compare(b.Version(), a.Version()) // newest first
+		})
+
+		var names []string
+		var bytes int64
+		for _, v := range mvs[keep:] {
+			if src, ok := pins.pinned(v); ok {
+				c.logf("mod cache: keeping %s (pinned by %s)", v, src)
+				continue
+			}
+			if !c.dryRun {
+				if err := evictModVersion(root, v); err != nil {
+					c.failf(root, "mod cache: failed to evict %s: %v", v, err)
+					continue
+				}
+			}
+			removed[v] = true
+			names = append(names, v.Version())
+			bytes += v.size
+		}
+		if len(names) == 0 {
+			continue
+		}
+		c.logf("mod cache: %s: %s %d of %d versions (%s), reclaiming %.1fMB",
+			path, verb, len(names), len(mvs), strings.Join(names, ", "), toMB(bytes))
+		reclaimed += bytes
+	}
+
+	for _, v := range versions {
+		if !removed[v] {
+			survivors = append(survivors, v)
+		}
+	}
+	return survivors, reclaimed
+}
+
+// expireModVersions evicts every unpinned module version not really used
+// within maxAge, logging each one. It returns the surviving versions, in their
+// original order, and the bytes reclaimed.
+func (c *Cleaner) expireModVersions(root string, versions []*modVersion, pins pinSet, maxAge time.Duration) (survivors []*modVersion, reclaimed int64) {
+	verb := "expired"
+	if c.dryRun {
+		verb = "would expire"
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, v := range versions {
+		if !v.lastUse.Before(cutoff) {
+			survivors = append(survivors, v)
+			continue
+		}
+		if src, ok := pins.pinned(v); ok {
+			c.logf("mod cache: keeping %s (pinned by %s)", v, src)
+			survivors = append(survivors, v)
+			continue
+		}
+		if !c.dryRun {
+			if err := evictModVersion(root, v); err != nil {
+				c.failf(root, "mod cache: failed to evict %s: %v", v, err)
+				survivors = append(survivors, v)
+				continue
+			}
+		}
+		c.logf("mod cache: %s %s (%.1fMB, last used %s)", verb, v, toMB(v.size), v.lastUse.Format(time.DateOnly))
+		reclaimed += v.size
+	}
+	return survivors, reclaimed
+}
@@ -0,0 +1,276 @@
+//go:build darwin || linux
+
+// Module versions are ordered by access time, which is only observable on
+// platforms with a real fileATime implementation, and the read-only trees Go
+// extracts rely on Unix permission semantics.
+
+package cleaner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+// writeModVersion lays out a module version the way Go does: a read-only
+// extracted tree at <escPath>@<escVer> plus its download files, all last used
+// age ago. Each of the two source files and the zip is size bytes.
+func writeModVersion(t *testing.T, root, escPath, escVer string, size int, age time.Duration) {
+	t.Helper()
+	when := time.Now().Add(-age)
+
+	dir := filepath.Join(root, filepath.FromSlash(escPath)+"@"+escVer)
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeSized(t, filepath.Join(dir, "go.mod"), 0444, size, when)
+	writeSized(t, filepath.Join(dir, "sub", "x.go"), 0444, size, when)
+	for _, d := range []string{filepath.Join(dir, "sub"), dir} {
+		if err := os.Chmod(d, 0555); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { _ = removeReadOnlyTree(dir) })
+
+	vdir := filepath.Join(root, "cache", "download", filepath.FromSlash(escPath), "@v")
+	if err := os.MkdirAll(vdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range []string{".info", ".mod", ".ziphash"} {
+		writeSized(t, filepath.Join(vdir, escVer+ext), 0644, 1, when)
+	}
+	writeSized(t, filepath.Join(vdir, escVer+".zip"), 0644, size, when)
+}
+
+// writeSized writes a file of size bytes with its access and modification
+// times set to when.
+func writeSized(t *testing.T, path string, mode os.FileMode, size int, when time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, make([]byte, size), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, when, when); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestModCacheVersions(t *testing.T) {
+	root := t.TempDir()
+	writeModVersion(t, root, "github.com/!burnt!sushi/toml", "v1.3.0", 10, 48*time.Hour)
+	writeModVersion(t, root, "example.com/foo", "v0.1.0", 10, time.Hour)
+
+	// A version with only its go.mod downloaded (module graph resolution).
+	vdir := filepath.Join(root, "cache", "download", "example.com", "foo", "@v")
+	writeFileAged(t, filepath.Join(vdir, "v0.0.9.mod"), 0644, time.Now().Add(-72*time.Hour), time.Now().Add(-72*time.Hour))
+	writeFileAged(t, filepath.Join(vdir, "list"), 0644, time.Now(), time.Now())
+
+	// Non-version content that must never be treated as a version, and a VCS
+	// clone, an entry of its own whose lock is never evicted.
+	for _, p := range []string{"cache/vcs/abc123/HEAD", "cache/vcs/abc123.info", "cache/vcs/abc123.lock", "cache/download/sumdb/sum.golang.org/lookup/x"} {
+		full := filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFileAged(t, full, 0644, time.Now().Add(-100*24*time.Hour), time.Now().Add(-100*24*time.Hour))
+	}
+
+	got := modCacheVersions(scanTree(root, false), nil)
+	var names []string
+	for _, v := range got {
+		names = append(names, v.String())
+	}
+	want := []string{"VCS clone abc123", "example.com/foo@v0.0.9", "github.com/BurntSushi/toml@v1.3.0", "example.com/foo@v0.1.0"}
+	if len(names) != len(want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("got %v, want %v (oldest first)", names, want)
+		}
+	}
+	if got[0].dir != filepath.Join(root, "cache", "vcs", "abc123") || len(got[0].files) != 1 {
+		t.Errorf("clone: dir=%q files=%v, want its tree and .info file", got[0].dir, got[0].files)
+	}
+	if got[2].dir == "" || len(got[2].files) != 4 {
+		t.Errorf("toml: dir=%q files=%v, want extracted dir and 4 download files", got[2].dir, got[2].files)
+	}
+	if got[1].dir != "" {
+		t.Errorf("foo@v0.0.9 was never extracted, got dir %q", got[1].dir)
+	}
+}
+
+// TestEvictModVersionReadOnly proves a version Go extracted read-only is
+// removed completely, leaving no partial directory or marker behind.
+func TestEvictModVersionReadOnly(t *testing.T) {
+	root := t.TempDir()
+	writeModVersion(t, root, "example.com/foo", "v1.0.0", 10, time.Hour)
+
+	versions := modCacheVersions(scanTree(root, false), nil)
+	if len(versions) != 1 {
+		t.Fatalf("got %d versions, want 1", len(versions))
+	}
+	if err := evictModVersion(root, versions[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists(filepath.Join(root, "example.com", "foo@v1.0.0")) {
+		t.Error("extracted directory should be gone")
+	}
+	vdir := filepath.Join(root, "cache", "download", "example.com", "foo", "@v")
+	entries, _ := os.ReadDir(vdir)
+	for _, e := range entries {
+		t.Errorf("unexpected leftover download file %s", e.Name())
+	}
+}
+
+func TestTrimModCacheEvictsOldestFirst(t *testing.T) {
+	root := t.TempDir()
+	writeModVersion(t, root, "example.com/old", "v1.0.0", 100, 72*time.Hour)
+	writeModVersion(t, root, "example.com/mid", "v1.0.0", 100, 48*time.Hour)
+	writeModVersion(t, root, "example.com/new", "v1.0.0", 100, time.Hour)
+
+	size := dirSize(root)
+	c := New(&config.Config{}, false, false)
+	removed, reclaimed := c.trimModCache(root, modCacheVersions(scanTree(root, false), nil), nil, size, size-1)
+
+	if removed != 1 {
+		t.Fatalf("removed=%d, want 1", removed)
+	}
+	if reclaimed < 300 {
+		t.Errorf("reclaimed=%d, want at least the 300 bytes of source and zip", reclaimed)
+	}
+	if exists(filepath.Join(root, "example.com", "old@v1.0.0")) {
+		t.Error("least recently used version should have been evicted")
+	}
+	for _, m := range []string{"mid", "new"} {
+		if !exists(filepath.Join(root, "example.com", m+"@v1.0.0")) {
+			t.Errorf("%s should have survived", m)
+		}
+	}
+}
+
+func TestTrimModCacheDryRun(t *testing.T) {
+	root := t.TempDir()
+	writeModVersion(t, root, "example.com/foo", "v1.0.0", 10, time.Hour)
+
+	c := New(&config.Config{}, true, false) // dry-run
+	if removed, _ := c.trimModCache(root, modCacheVersions(scanTree(root, false), nil), nil, dirSize(root), 0); removed != 1 {
+		t.Fatalf("removed=%d, want 1 (dry-run should still report)", removed)
+	}
+	if !exists(filepath.Join(root, "example.com", "foo@v1.0.0")) {
+		t.Error("dry-run must not evict")
+	}
+}
+
+func TestPruneModVersionsKeepsNewest(t *testing.T) {
+	root := t.TempDir()
+	for i, v := range []string{"v1.0.0", "v1.2.0", "v1.10.0", "v2.0.0-rc.1"} {
+		writeModVersion(t, root, "example.com/foo", v, 10, time.Duration(i+1)*time.Hour)
+	}
+	writeModVersion(t, root, "example.com/bar", "v0.1.0", 10, time.Hour)
+
+	gosum := filepath.Join(t.TempDir(), "go.sum")
+	if err := os.WriteFile(gosum, []byte("example.com/foo v1.0.0 h1:abc=\nexample.com/foo v1.0.0/go.mod h1:def=\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(&config.Config{}, false, false)
+	survivors, reclaimed := c.pruneModVersions(root, modCacheVersions(scanTree(root, false), nil), 2, referencedVersions([]string{gosum}))
+
+	if reclaimed == 0 {
+		t.Error("expected bytes reclaimed")
+	}
+	// Newest two by semver (v2.0.0-rc.1, v1.10.0), plus v1.0.0 pinned by go.sum.
+	for _, v := range []string{"v2.0.0-rc.1", "v1.10.0", "v1.0.0"} {
+		if !exists(filepath.Join(root, "example.com", "foo@"+v)) {
+			t.Errorf("foo@%s should have been kept", v)
+		}
+	}
+	if exists(filepath.Join(root, "example.com", "foo@v1.2.0")) {
+		t.Error("foo@v1.2.0 should have been pruned")
+	}
+	if !exists(filepath.Join(root, "example.com", "bar@v0.1.0")) {
+		t.Error("bar has a single version and should be untouched")
+	}
+	if len(survivors) != 4 {
+		t.Errorf("got %d survivors, want 4", len(survivors))
+	}
+}
+
+func TestTrimModCacheSkipsPinned(t *testing.T) {
+	root := t.TempDir()
+	writeModVersion(t, root, "example.com/old", "v1.0.0", 100, 72*time.Hour)
+	writeModVersion(t, root, "example.com/new", "v1.0.0", 100, time.Hour)
+
+	ws := t.TempDir()
+	if err := os.WriteFile(filepath.Join(ws, "go.sum"), []byte("example.com/old v1.0.0 h1:x=\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pins := make(pinSet)
+	pins.addWorkspaces([]string{ws})
+
+	c := New(&config.Config{}, false, false)
+	removed, _ := c.trimModCache(root, modCacheVersions(scanTree(root, false), nil), pins, dirSize(root), 0)
+
+	if removed != 1 {
+		t.Fatalf("removed=%d, want 1", removed)
+	}
+	if !exists(filepath.Join(root, "example.com", "old@v1.0.0")) {
+		t.Error("pinned version must survive even though it is least recently used")
+	}
+	if exists(filepath.Join(root, "example.com", "new@v1.0.0")) {
+		t.Error("unpinned version should have been evicted")
+	}
+}
+
+// TestExpireModVersionsRemovesStaleClone verifies a VCS clone unused for
+// longer than max_age is evicted, .info file first, leaving Go's lock file,
+// while a clone Go has locked is kept.
+func TestExpireModVersionsRemovesStaleClone(t *testing.T) {
+	root := t.TempDir()
+	vcs := filepath.Join(root, "cache", "vcs")
+	old := time.Now().Add(-100 * 24 * time.Hour)
+	for _, hash := range []string{"stale", "busy"} {
+		if err := os.MkdirAll(filepath.Join(vcs, hash, "objects"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{filepath.Join(hash, "HEAD"), filepath.Join(hash, "objects", "pack"), hash + ".info", hash + ".lock"} {
+			writeFileAged(t, filepath.Join(vcs, p), 0644, old, old)
+		}
+	}
+	writeModVersion(t, root, "example.com/foo", "v1.0.0", 10, time.Hour)
+
+	busy, err := os.Open(filepath.Join(vcs, "busy.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = busy.Close() }()
+	if ok, err := flockFile(busy); !ok || err != nil {
+		t.Fatalf("locking the busy clone: %v", err)
+	}
+
+	c := New(&config.Config{}, false, false)
+	survivors, _ := c.expireModVersions(root, modCacheVersions(scanTree(root, false), nil), nil, 30*24*time.Hour)
+
+	if len(survivors) != 2 {
+		t.Errorf("got %d survivors, want the busy clone and foo@v1.0.0", len(survivors))
+	}
+	for _, p := range []string{"stale", "stale.info"} {
+		if exists(filepath.Join(vcs, p)) {
+			t.Errorf("%s should have been evicted", p)
+		}
+	}
+	for _, p := range []string{"stale.lock", "busy", "busy.info", "busy.lock"} {
+		if !exists(filepath.Join(vcs, p)) {
+			t.Errorf("%s should be kept", p)
+		}
+	}
+}
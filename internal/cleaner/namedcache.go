@@ -0,0 +1,188 @@
+package cleaner
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+// named returns the configuration of the cache at path.
+func (c *Cleaner) named(path string) (config.NamedCache, bool) {
+	for _, n := range c.cfg.AllCaches() {
+		if n.Path == path {
+			return n, true
+		}
+	}
+	return config.NamedCache{}, false
+}
+
+// namedEntry is one evictable entry of a cache other than Go's own: a file,
+// or a directory evicted whole.
+type namedEntry struct {
+	path    string
+	size    int64
+	lastUse time.Time
+	file    *scanFile // the scanned file, for a files cache
+}
+
+// namedCacheEntries scans the cache n and returns its entries, least recently
+// used first, along with the scan totals. A files cache's entries are its
+// files, used when last modified. A dirs cache's entries are the directories
+// matching its entries glob, each used when any file in it last was, going by
+// access times that keep-warm didn't set.
+func (c *Cleaner) namedCacheEntries(n config.NamedCache) ([]*namedEntry, *cacheScan) {
+	var entries []*namedEntry
+	if n.Kind == config.KindFiles {
+		s := c.scan(n.Path)
+		for _, f := range s.live() {
+			entries = append(entries, &namedEntry{path: f.path, size: f.size, lastUse: f.mtime, file: f})
+		}
+		slices.SortStableFunc(entries, func(a, b *namedEntry) int { return a.lastUse.Compare(b.lastUse) })
+		return entries, s
+	}
+
+	total := &cacheScan{root: n.Path}
+	if _, err := os.Stat(n.Path); err != nil {
+		total.err = err
+		return nil, total
+	}
+	matches, _ := filepath.Glob(filepath.Join(n.Path, cmp.Or(n.Entries, "*")))
+	for _, m := range matches {
+		if info, err := os.Lstat(m); err != nil || !info.IsDir() {
+			continue
+		}
+		s := scanTree(m, n.Apparent())
+		e := &namedEntry{path: m, size: s.size}
+		for _, f := range s.files {
+			if t := c.realUse(f); t.After(e.lastUse) {
+				e.lastUse = t
+			}
+		}
+		total.size += s.size
+		total.apparent += s.apparent
+		total.allocated += s.allocated
+		entries = append(entries, e)
+	}
+	slices.SortStableFunc(entries, func(a, b *namedEntry) int { return a.lastUse.Compare(b.lastUse) })
+	return entries, total
+}
+
+// realUse returns when the file f was last really used: its access time,
+// unless keep-warm set that while warming any cache holding it, and never
+// earlier than its modification time.
+func (c *Cleaner) realUse(f *scanFile) time.Time {
+	for _, n := range c.cfg.AllCaches() {
+		if n.Path != "" && within(f.path, n.Path) && c.usage(n.Path).synthetic(f.atime) {
+			return f.mtime
+		}
+	}
+	if f.atime.Before(f.mtime) {
+		return f.mtime
+	}
+	return f.atime
+}
+
+// removeNamedEntries removes entries, oldest first, for as long as evict
+// reports true for the next one, given the bytes reclaimed so far. It returns
+// the entries left, the number removed and the bytes reclaimed. In dry-run
+// mode nothing is deleted, but the counts reflect what would be.
+func (c *Cleaner) removeNamedEntries(entries []*namedEntry, evict func(e *namedEntry, reclaimed int64) bool) (survivors []*namedEntry, removed int, reclaimed int64) {
+	for i, e := range entries {
+		if !evict(e, reclaimed) {
+			return append(survivors, entries[i:]...), removed, reclaimed
+		}
+		if !c.dryRun {
+			var err error
+			if e.file != nil {
+				err = os.Remove(e.path)
+			} else {
+				err = removeReadOnlyTree(e.path)
+			}
+			if err != nil {
+				survivors = append(survivors, e)
+				continue
+			}
+			if e.file != nil {
+				e.file.removed = true
+			}
+		}
+		removed++
+		reclaimed += e.size
+	}
+	return survivors, removed, reclaimed
+}
+
+// cleanNamedCache enforces the age, size and free-space limits of a cache
+// other than Go's own, such as gopls's. Purging it removes every entry. Like
+// cleanBuildCache, it reports whether the cache was purged outright.
+func (c *Cleaner) cleanNamedCache(n config.NamedCache) bool {
+	path := n.Path
+	if !c.safeToClean(path) {
+		return false
+	}
+	name := n.Name + " cache"
+	cc := n.CacheConfig
+	if cc.High().IsZero() {
+		// No size limit. A cache only reaches its whole filesystem's size by
+		// filling it, which min_free, if set, acts on long before.
+		cc.MaxSize = config.Size{Percent: 100}
+	}
+
+	entries, s := c.namedCacheEntries(n)
+	if s.err != nil {
+		c.failf(path, "%s: %v", name, s.err)
+		return false
+	}
+	size := s.size
+	c.logf("%s: %s (%s)", name, path, s.describe(cc))
+	total, avail := c.freeSpace(name, cc, path)
+	c.reportSize(cc, s, total, avail)
+
+	verbs := func(done, would string) string {
+		if c.dryRun {
+			return would
+		}
+		return done
+	}
+	var freed int64
+	if cc.MaxAge > 0 {
+		cutoff := time.Now().Add(-time.Duration(cc.MaxAge))
+		var removed int
+		entries, removed, freed = c.removeNamedEntries(entries, func(e *namedEntry, _ int64) bool {
+			return e.lastUse.Before(cutoff)
+		})
+		if removed > 0 {
+			c.logf("%s: %s %d entries unused for %s, reclaiming %.1fGB", name, verbs("expired", "would expire"), removed, cc.MaxAge, toGB(freed))
+		}
+		c.record(path, ActionExpire, removed, freed)
+	}
+	defer func() { c.logProjectedFree(name, avail, freed) }()
+
+	plan := planCleanup(cc, size-freed, total, avail+freed)
+	if !plan.clean {
+		return false
+	}
+	c.reportPlan(path, plan)
+
+	if cc.Purge() {
+		c.logf("purging %s (%s)", name, plan.reason)
+		_, removed, reclaimed := c.removeNamedEntries(entries, func(*namedEntry, int64) bool { return true })
+		c.record(path, ActionPurge, removed, reclaimed)
+		freed += reclaimed
+		return true
+	}
+
+	c.logf("trimming %s to %.1fGB (%s)", name, toGB(plan.target), plan.reason)
+	remaining := size - freed
+	_, removed, reclaimed := c.removeNamedEntries(entries, func(_ *namedEntry, reclaimed int64) bool {
+		return remaining-reclaimed > plan.target
+	})
+	freed += reclaimed
+	c.logf("%s: %s %d entries, reclaiming %.1fGB", name, verbs("trimmed", "would trim"), removed, toGB(reclaimed))
+	c.record(path, ActionTrim, removed, reclaimed)
+	return false
+}
@@ -0,0 +1,95 @@
+package cleaner
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+// writeAged writes size bytes to path, creating its directory, with the given
+// access and modification times.
+func writeAged(t *testing.T, path string, size int, atime, mtime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanNamedCaches(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+
+	// A files cache over its limit loses its least recently modified files.
+	files := t.TempDir()
+	writeAged(t, filepath.Join(files, "a"), 1000, now, ago(72*time.Hour))
+	writeAged(t, filepath.Join(files, "b"), 1000, ago(72*time.Hour), ago(time.Hour))
+	writeAged(t, filepath.Join(files, "sub", "c"), 1000, now, ago(48*time.Hour))
+
+	// A dirs cache expires whole entries, going by real access times.
+	dirs := t.TempDir()
+	writeAged(t, filepath.Join(dirs, "old", "f"), 10, ago(100*24*time.Hour), ago(100*24*time.Hour))
+	if err := os.Chmod(filepath.Join(dirs, "old"), 0555); err != nil {
+		t.Fatal(err)
+	}
+	writeAged(t, filepath.Join(dirs, "used", "f"), 10, ago(time.Hour), ago(100*24*time.Hour))
+	writeAged(t, filepath.Join(dirs, "stray.lock"), 10, ago(100*24*time.Hour), ago(100*24*time.Hour))
+
+	cfg := &config.Config{Caches: []config.NamedCache{
+		{Name: "lint", Kind: config.KindFiles, CacheConfig: config.CacheConfig{
+			Path: files, Measure: config.MeasureApparent, MaxSize: config.Size{Bytes: 2500}, TargetSize: config.Size{Bytes: 1500}}},
+		{Name: "clones", Kind: config.KindDirs, CacheConfig: config.CacheConfig{
+			Path: dirs, MaxAge: config.Duration(30 * 24 * time.Hour)}},
+		{Name: "missing", Kind: config.KindFiles, CacheConfig: config.CacheConfig{Path: filepath.Join(dirs, "nope")}},
+	}}
+	c := New(cfg, false, false)
+	c.SetOutput(io.Discard)
+
+	// An idle entry keep-warm refreshed still counts as unused.
+	warmed := filepath.Join(dirs, "warmed", "f")
+	stamp := c.usage(dirs).stamp(now)
+	writeAged(t, warmed, 10, stamp, ago(100*24*time.Hour))
+
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"a", "sub/c"} {
+		if _, err := os.Stat(filepath.Join(files, p)); !os.IsNotExist(err) {
+			t.Errorf("%s should have been trimmed", p)
+		}
+	}
+	for _, p := range []string{filepath.Join(files, "b"), filepath.Join(dirs, "used", "f"), filepath.Join(dirs, "stray.lock")} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s should have survived: %v", p, err)
+		}
+	}
+	for _, p := range []string{"old", "warmed"} {
+		if _, err := os.Stat(filepath.Join(dirs, p)); !os.IsNotExist(err) {
+			t.Errorf("%s should have expired", p)
+		}
+	}
+
+	r := c.Report()
+	if len(r.Caches) != 2 {
+		t.Fatalf("got %d cache reports, want 2: a missing cache is left out", len(r.Caches))
+	}
+	for i, want := range []struct {
+		name, action string
+		removed      int
+	}{{"lint", ActionTrim, 2}, {"clones", ActionExpire, 2}} {
+		cr := r.Caches[i]
+		if cr.Name != want.name || len(cr.Actions) != 1 || cr.Actions[0] != want.action || cr.Removed != want.removed {
+			t.Errorf("report %+v, want %s to %s %d entries", cr, want.name, want.action, want.removed)
+		}
+	}
+}
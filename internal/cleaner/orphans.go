@@ -0,0 +1,190 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+// Where an orphaned cache location came from, as listed in OrphanCache.Source.
+const (
+	OrphanGoDefault = "go-default" // Go's own default location
+	OrphanLegacy    = "legacy"     // where older cachegoat versions relocated caches
+	OrphanRecorded  = "recorded"   // a location cachegoat cleaned or migrated from before
+)
+
+// legacyRelocations are the fixed paths older cachegoat versions moved the
+// caches to, before relocate_to.
+var legacyRelocations = map[string]string{"build": "/tmp/go-cache", "mod": "/tmp/go-mod-cache"}
+
+// OrphanCache is a cache directory Go no longer uses but that still takes up
+// space, typically left behind by relocating the caches.
+type OrphanCache struct {
+	Name      string `json:"name"` // "build" or "mod"
+	Path      string `json:"path"`
+	Source    string `json:"source"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// knownLocations is every cache path cachegoat has cleaned, by cache name,
+// remembered so it can find them again once Go has moved on.
+type knownLocations map[string][]string
+
+func locationsFile(cfg *config.Config) string {
+	if cfg.StateDir == "" {
+		return ""
+	}
+	return filepath.Join(cfg.StateDir, "locations.json")
+}
+
+func loadLocations(cfg *config.Config) knownLocations {
+	locs := make(knownLocations)
+	if file := locationsFile(cfg); file != "" {
+		if data, err := os.ReadFile(file); err == nil {
+			_ = json.Unmarshal(data, &locs)
+		}
+	}
+	return locs
+}
+
+func (l knownLocations) save(cfg *config.Config) error {
+	file := locationsFile(cfg)
+	if file == "" {
+		return nil
+	}
+	return writeJSONAtomic(file, l)
+}
+
+// recordLocations remembers the caches this run cleaned, so that once they're
+// relocated Recommend can still find what's left of them.
+func (c *Cleaner) recordLocations() {
+	locs := loadLocations(c.cfg)
+	changed := false
+	for name, path := range map[string]string{"build": c.cfg.BuildCache.Path, "mod": c.cfg.ModCache.Path} {
+		if path != "" && !slices.Contains(locs[name], path) {
+			locs[name] = append(locs[name], path)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := locs.save(c.cfg); err != nil {
+		c.failf("", "failed to save cache locations: %v", err)
+	}
+}
+
+// findOrphans returns the cache directories Go isn't using, in any profile,
+// that cachegoat knows of: Go's default locations, where older cachegoat
+// versions relocated caches, and every location recorded in the state
+// directory. A location still waiting to be migrated is left to --migrate, and
+// one is only counted if it looks like the kind of cache it's meant to be, so
+// nothing else is ever offered for deletion.
+func findOrphans(cfg *config.Config) []OrphanCache {
+	// Go's own caches are in use whatever cachegoat is configured with, as
+	// when a configured path differs from go env's.
+	var inUse []string
+	goBuild, goMod := goEnvCaches()
+	for _, p := range []string{goBuild, goMod} {
+		if p != "" {
+			inUse = append(inUse, resolvePath(p))
+		}
+	}
+	for _, name := range cfg.ProfileNames() {
+		pc, _ := cfg.Profile(name)
+		for _, p := range []string{pc.BuildCache.Path, pc.ModCache.Path} {
+			if p != "" {
+				inUse = append(inUse, resolvePath(p))
+			}
+		}
+	}
+	var skip []string
+	for _, m := range loadMigrations(cfg) {
+		if !m.Done {
+			skip = append(skip, resolvePath(m.From))
+		}
+	}
+
+	var candidates []OrphanCache
+	build, mod := goDefaultCaches()
+	candidates = append(candidates,
+		OrphanCache{Name: "build", Path: build, Source: OrphanGoDefault},
+		OrphanCache{Name: "mod", Path: mod, Source: OrphanGoDefault},
+		OrphanCache{Name: "build", Path: legacyRelocations["build"], Source: OrphanLegacy},
+		OrphanCache{Name: "mod", Path: legacyRelocations["mod"], Source: OrphanLegacy},
+	)
+	locs := loadLocations(cfg)
+	for _, m := range loadMigrations(cfg) {
+		locs[m.Name] = append(locs[m.Name], m.From)
+	}
+	for _, name := range []string{"build", "mod"} {
+		for _, p := range locs[name] {
+			candidates = append(candidates, OrphanCache{Name: name, Path: p, Source: OrphanRecorded})
+		}
+	}
+
+	var orphans []OrphanCache
+	var seen []string
+	for _, o := range candidates {
+		if o.Path == "" || !looksLikeCache(o.Name, o.Path) {
+			continue
+		}
+		p := resolvePath(o.Path)
+		if slices.Contains(seen, p) || slices.Contains(skip, p) || slices.ContainsFunc(inUse, func(u string) bool {
+			return within(u, p) || within(p, u)
+		}) {
+			continue
+		}
+		seen = append(seen, p)
+		o.SizeBytes = dirSize(o.Path)
+		orphans = append(orphans, o)
+	}
+	return orphans
+}
+
+// looksLikeCache reports whether dir is the current user's and has the marks
+// of a Go cache of the named kind: the README Go writes into a build cache, or
+// a module cache's download directory.
+func looksLikeCache(name, dir string) bool {
+	// Shared spots like /tmp/go-cache may hold another user's cache.
+	if info, err := os.Lstat(dir); err != nil || !info.IsDir() || !ownedByCurrentUser(info) {
+		return false
+	}
+	switch name {
+	case "build":
+		data, err := os.ReadFile(filepath.Join(dir, "README"))
+		return err == nil && strings.Contains(string(data), "go clean -cache")
+	case "mod":
+		info, err := os.Stat(filepath.Join(dir, "cache", "download"))
+		return err == nil && info.IsDir()
+	}
+	return false
+}
+
+// purgeOrphans deletes the orphaned caches and forgets them, reporting each to
+// w.
+func purgeOrphans(w io.Writer, cfg *config.Config, orphans []OrphanCache) error {
+	locs := loadLocations(cfg)
+	var errs []string
+	for _, o := range orphans {
+		if err := removeReadOnlyTree(o.Path); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", o.Path, err))
+			continue
+		}
+		locs[o.Name] = slices.DeleteFunc(locs[o.Name], func(p string) bool { return p == o.Path })
+		_, _ = fmt.Fprintf(w, "✅ Deleted %s (%.1fGB)\n", o.Path, toGB(o.SizeBytes))
+	}
+	if err := locs.save(cfg); err != nil {
+		errs = append(errs, fmt.Sprintf("failed to save cache locations: %v", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to delete %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
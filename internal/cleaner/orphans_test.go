@@ -0,0 +1,96 @@
+//go:build darwin || linux
+
+package cleaner
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+// writeBuildCacheDir makes dir look like a Go build cache.
+func writeBuildCacheDir(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	readme := "This directory holds cached build artifacts from the Go build system.\nRun \"go clean -cache\" if the directory is getting too large.\n"
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte(readme), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindAndPurgeOrphans(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("HOME", root)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(root, ".cache"))
+	t.Setenv("GOPATH", filepath.Join(root, "go"))
+	t.Setenv("GOENV", "off")
+	// Go itself uses a cache cachegoat isn't configured with.
+	live := filepath.Join(root, "legacy-build")
+	t.Setenv("GOCACHE", live)
+	t.Setenv("GOMODCACHE", filepath.Join(root, "scratch", "mod"))
+	orig := legacyRelocations
+	legacyRelocations = map[string]string{"build": filepath.Join(root, "legacy-build"), "mod": filepath.Join(root, "legacy-mod")}
+	t.Cleanup(func() { legacyRelocations = orig })
+
+	defaultBuild, defaultMod := goDefaultCaches()
+	writeBuildCacheDir(t, defaultBuild)
+	if err := os.MkdirAll(filepath.Join(defaultMod, "cache", "download"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	recorded := filepath.Join(root, "old-build")
+	writeBuildCacheDir(t, recorded)
+	pending := filepath.Join(root, "pending-build")
+	writeBuildCacheDir(t, pending)
+	notACache := filepath.Join(root, "legacy-mod") // no cache/download
+	if err := os.MkdirAll(notACache, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeBuildCacheDir(t, live)
+	current := filepath.Join(root, "scratch", "go-cache")
+	writeBuildCacheDir(t, current)
+
+	cfg := &config.Config{BuildCache: config.CacheConfig{Path: current}, StateDir: t.TempDir()}
+	if err := (knownLocations{"build": {recorded, current}}).save(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveMigrations(cfg, []*Migration{{Name: "build", From: pending, To: current}}); err != nil {
+		t.Fatal(err)
+	}
+
+	orphans := findOrphans(cfg)
+	got := make(map[string]string)
+	for _, o := range orphans {
+		got[o.Path] = o.Source
+	}
+	want := map[string]string{defaultBuild: OrphanGoDefault, defaultMod: OrphanGoDefault, recorded: OrphanRecorded}
+	if len(got) != len(want) {
+		t.Fatalf("orphans = %v, want %v", got, want)
+	}
+	for p, src := range want {
+		if got[p] != src {
+			t.Errorf("%s: source %q, want %q", p, got[p], src)
+		}
+	}
+
+	if err := purgeOrphans(io.Discard, cfg, orphans); err != nil {
+		t.Fatal(err)
+	}
+	for p := range want {
+		if exists(p) {
+			t.Errorf("%s should be deleted", p)
+		}
+	}
+	for _, p := range []string{current, live, pending, notACache} {
+		if !exists(p) {
+			t.Errorf("%s should be kept", p)
+		}
+	}
+	if locs := loadLocations(cfg); len(locs["build"]) != 1 || locs["build"][0] != current {
+		t.Errorf("recorded locations = %v, want just the current cache", locs)
+	}
+}
@@ -0,0 +1,143 @@
+package cleaner
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// pinSet maps a module version ("path@version") that must survive selective
+// eviction to the file that references it.
+type pinSet map[string]string
+
+func (p pinSet) add(path, version, source string) {
+	key := path + "@" + version
+	if _, ok := p[key]; !ok {
+		p[key] = source
+	}
+}
+
+// pinned reports whether v is pinned, and by which file.
+func (p pinSet) pinned(v *modVersion) (string, bool) {
+	src, ok := p[v.String()]
+	return src, ok
+}
+
+// referencedVersions collects the module versions named by the given go.mod,
+// go.sum and go.work files.
+func referencedVersions(files []string) pinSet {
+	pins := make(pinSet)
+	for _, f := range files {
+		pins.addFile(f)
+	}
+	return pins
+}
+
+// addFile pins the module versions named by a go.mod, go.sum, go.work or
+// go.work.sum file, chosen by its base name. Unreadable files are skipped: a
+// missing reference file should not stop the rest of the cleanup.
+func (p pinSet) addFile(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+	switch filepath.Base(file) {
+	case "go.sum", "go.work.sum":
+		p.addGoSum(file, data)
+	case "go.work":
+		p.addGoWork(file, data)
+	default:
+		p.addGoMod(file, data)
+	}
+}
+
+// addWorkspaces pins every module version referenced by the Go project files
+// found anywhere under the given directories.
+func (p pinSet) addWorkspaces(dirs []string) {
+	walkGoFiles(dirs, p.addFile)
+}
+
+// walkGoFiles calls fn with every go.mod, go.sum, go.work and go.work.sum file
+// under the given directories. Directories the go command itself ignores
+// (those starting with "." or "_", and testdata) are skipped, as is vendor,
+// whose modules are already covered by the go.mod beside it.
+func walkGoFiles(dirs []string, fn func(file string)) {
+	for _, dir := range dirs {
+		_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if path != dir && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			switch d.Name() {
+			case "go.mod", "go.sum", "go.work", "go.work.sum":
+				fn(path)
+			}
+			return nil
+		})
+	}
+}
+
+// addGoMod pins every required module version in a go.mod file, plus the
+// targets of versioned replace directives. A file the strict parser rejects
+// (say, one written by a newer Go) still contributes its requirements via the
+// lax parser, which ignores replace directives.
+func (p pinSet) addGoMod(file string, data []byte) {
+	mf, err := modfile.Parse(file, data, nil)
+	if err != nil {
+		if mf, err = modfile.ParseLax(file, data, nil); err != nil {
+			return
+		}
+	}
+	for _, r := range mf.Require {
+		p.add(r.Mod.Path, r.Mod.Version, file)
+	}
+	for _, r := range mf.Replace {
+		if r.New.Version != "" {
+			p.add(r.New.Path, r.New.Version, file)
+		}
+	}
+}
+
+// addGoWork pins the targets of a go.work file's versioned replace directives
+// and everything referenced by the modules it uses. Used modules that live
+// outside the scanned directories would otherwise be missed.
+func (p pinSet) addGoWork(file string, data []byte) {
+	wf, err := modfile.ParseWork(file, data, nil)
+	if err != nil {
+		return
+	}
+	for _, r := range wf.Replace {
+		if r.New.Version != "" {
+			p.add(r.New.Path, r.New.Version, file)
+		}
+	}
+	for _, u := range wf.Use {
+		dir := u.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(file), dir)
+		}
+		p.addFile(filepath.Join(dir, "go.mod"))
+		p.addFile(filepath.Join(dir, "go.sum"))
+	}
+}
+
+// addGoSum pins every module version listed in a go.sum (or go.work.sum)
+// file. Lines have the form "<path> <version>[/go.mod] <hash>".
+func (p pinSet) addGoSum(file string, data []byte) {
+	for line := range strings.SplitSeq(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		p.add(fields[0], strings.TrimSuffix(fields[1], "/go.mod"), file)
+	}
+}
@@ -0,0 +1,90 @@
+package cleaner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReferencedVersions(t *testing.T) {
+	dir := t.TempDir()
+	gomod := filepath.Join(dir, "go.mod")
+	gosum := filepath.Join(dir, "go.sum")
+
+	mod := `module example.com/app
+
+go 1.25
+
+require (
+	example.com/a v1.0.0
+	example.com/b v0.2.0 // indirect
+)
+
+replace example.com/c => example.com/c-fork v0.3.0
+
+replace example.com/d => ../d
+`
+	sum := `example.com/a v1.0.0 h1:aaa=
+example.com/a v1.0.0/go.mod h1:bbb=
+example.com/e v1.5.0/go.mod h1:ccc=
+`
+	if err := os.WriteFile(gomod, []byte(mod), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(gosum, []byte(sum), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pins := referencedVersions([]string{gomod, gosum, filepath.Join(dir, "missing", "go.mod")})
+	want := map[string]string{
+		"example.com/a@v1.0.0":      gomod,
+		"example.com/b@v0.2.0":      gomod,
+		"example.com/c-fork@v0.3.0": gomod,
+		"example.com/e@v1.5.0":      gosum,
+	}
+	if len(pins) != len(want) {
+		t.Fatalf("got %v, want %v", pins, want)
+	}
+	for k, src := range want {
+		if pins[k] != src {
+			t.Errorf("pins[%q] = %q, want %q", k, pins[k], src)
+		}
+	}
+}
+
+func TestAddWorkspaces(t *testing.T) {
+	ws := t.TempDir()
+	outside := t.TempDir()
+
+	files := map[string]string{
+		filepath.Join(ws, "go.work"):                   "go 1.25\n\nuse (\n\t./app\n\t" + outside + "\n)\n",
+		filepath.Join(ws, "app", "go.mod"):             "module example.com/app\n\ngo 1.25\n\nrequire example.com/a v1.0.0\n",
+		filepath.Join(ws, "app", "testdata", "go.mod"): "module example.com/fixture\n\ngo 1.25\n\nrequire example.com/ignored v1.0.0\n",
+		filepath.Join(ws, ".hidden", "go.sum"):         "example.com/ignored v2.0.0 h1:x=\n",
+		filepath.Join(outside, "go.sum"):               "example.com/b v0.1.0 h1:y=\n",
+	}
+	for path, data := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pins := make(pinSet)
+	pins.addWorkspaces([]string{ws})
+
+	want := map[string]string{
+		"example.com/a@v1.0.0": filepath.Join(ws, "app", "go.mod"),
+		"example.com/b@v0.1.0": filepath.Join(outside, "go.sum"),
+	}
+	if len(pins) != len(want) {
+		t.Fatalf("got %v, want %v", pins, want)
+	}
+	for k, src := range want {
+		if pins[k] != src {
+			t.Errorf("pins[%q] = %q, want %q", k, pins[k], src)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package cleaner
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// process is a running process, as the platform lists it.
+type process struct {
+	pid, ppid int
+	exe       string   // the executable's path, where the platform tells
+	args      []string // its command line, starting with the name it was run by
+}
+
+// Blocker is a running process that kept a run from cleaning, because it
+// matched one of protect_commands.
+type Blocker struct {
+	PID     int    `json:"pid"`
+	Command string `json:"command"` // the protect_commands entry it matched
+	Args    string `json:"args"`    // its command line
+}
+
+// goBuildActive returns a running process that one of commands matches, or
+// nil if there's none. It is a var so tests can substitute it.
+var goBuildActive = func(commands []string) *Blocker {
+	return findBlocker(listProcesses(), os.Getpid(), commands)
+}
+
+// findBlocker returns the first of procs, by PID, that one of commands
+// matches, leaving out self and its ancestors: cachegoat started by go run
+// mustn't wait for itself.
+func findBlocker(procs []process, self int, commands []string) *Blocker {
+	parent := make(map[int]int, len(procs))
+	for _, p := range procs {
+		parent[p.pid] = p.ppid
+	}
+	own := map[int]bool{}
+	for pid := self; pid > 0 && !own[pid]; pid = parent[pid] {
+		own[pid] = true
+	}
+
+	procs = slices.SortedFunc(slices.Values(procs), func(a, b process) int { return a.pid - b.pid })
+	for _, p := range procs {
+		if own[p.pid] {
+			continue
+		}
+		for _, cmd := range commands {
+			if matchCommand(p, cmd) {
+				return &Blocker{PID: p.pid, Command: cmd, Args: strings.Join(p.args, " ")}
+			}
+		}
+	}
+	return nil
+}
+
+// valueFlags are the go command's flags that take their value as the next
+// argument, as in go -C dir build, rather than after an =.
+var valueFlags = map[string]bool{
+	"C": true, "o": true, "p": true, "mod": true, "modfile": true, "overlay": true,
+	"pgo": true, "pkgdir": true, "tags": true, "toolexec": true, "buildmode": true,
+	"compiler": true, "asmflags": true, "gccgoflags": true, "gcflags": true, "ldflags": true,
+	"covermode": true, "coverpkg": true, "exec": true, "run": true, "skip": true,
+	"bench": true, "benchtime": true, "count": true, "cpu": true, "parallel": true,
+	"timeout": true, "fuzz": true, "fuzztime": true, "fuzzminimizetime": true,
+	"shuffle": true, "outputdir": true, "coverprofile": true, "cpuprofile": true,
+	"memprofile": true, "memprofilerate": true, "blockprofile": true,
+	"blockprofilerate": true, "mutexprofile": true, "mutexprofilefraction": true,
+	"trace": true, "vet": true, "list": true,
+}
+
+// matchCommand reports whether p is running cmd, a protect_commands entry:
+// an executable followed by the arguments p's must start with once its flags
+// are left out, along with the values of a go command's valueFlags. Each word
+// may be a glob. An executable given as a name matches p's executable or the
+// name p was run by, wherever it lives; one given as a path must match the
+// whole path.
+func matchCommand(p process, cmd string) bool {
+	words := strings.Fields(cmd)
+	if len(words) == 0 || len(p.args) == 0 || !matchExe(p, words[0]) {
+		return false
+	}
+	isGo := matchExe(p, "go")
+	var args []string
+	for i := 1; i < len(p.args); i++ {
+		a := p.args[i]
+		if !strings.HasPrefix(a, "-") {
+			args = append(args, a)
+			continue
+		}
+		if name := strings.TrimLeft(a, "-"); isGo && valueFlags[name] {
+			i++ // its value
+		}
+	}
+	if len(args) < len(words)-1 {
+		return false
+	}
+	for i, w := range words[1:] {
+		if ok, _ := filepath.Match(w, args[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func matchExe(p process, pattern string) bool {
+	for _, exe := range []string{p.exe, p.args[0]} {
+		if exe == "" {
+			continue
+		}
+		if !strings.ContainsRune(pattern, filepath.Separator) {
+			exe = strings.TrimSuffix(filepath.Base(exe), ".exe")
+		}
+		if ok, _ := filepath.Match(pattern, exe); ok {
+			return true
+		}
+	}
+	return false
+}
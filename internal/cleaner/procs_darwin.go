@@ -0,0 +1,33 @@
+//go:build darwin
+
+package cleaner
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// listProcesses lists the running processes with ps. Its command column joins
+// each process's arguments with spaces, so an argument holding one comes back
+// split, and the executable is known only by the name it was run by.
+func listProcesses() []process {
+	out, err := exec.Command("ps", "-axww", "-o", "pid=,ppid=,command=").Output()
+	if err != nil {
+		return nil
+	}
+	var procs []process
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 3 {
+			continue
+		}
+		pid, err := strconv.Atoi(f[0])
+		if err != nil {
+			continue
+		}
+		ppid, _ := strconv.Atoi(f[1])
+		procs = append(procs, process{pid: pid, ppid: ppid, args: f[2:]})
+	}
+	return procs
+}
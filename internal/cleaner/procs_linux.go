@@ -0,0 +1,52 @@
+//go:build linux
+
+package cleaner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// listProcesses lists the running processes from /proc. A process that exits
+// mid-scan, or has no command line, such as a kernel thread, is left out.
+func listProcesses() []process {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var procs []process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", e.Name())
+		cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+		if err != nil || len(cmdline) == 0 {
+			continue
+		}
+		p := process{pid: pid, args: strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")}
+		if len(p.args) == 1 {
+			// A process that rewrote its command line, as setproctitle
+			// does, often leaves its arguments space-separated.
+			p.args = strings.Fields(p.args[0])
+		}
+		if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+			p.exe = strings.TrimSuffix(exe, " (deleted)")
+		}
+		// stat is "pid (comm) state ppid ...", where comm may hold spaces
+		// and parentheses of its own.
+		if stat, err := os.ReadFile(filepath.Join(dir, "stat")); err == nil {
+			if i := bytes.LastIndexByte(stat, ')'); i >= 0 {
+				if f := strings.Fields(string(stat[i+1:])); len(f) > 1 {
+					p.ppid, _ = strconv.Atoi(f[1])
+				}
+			}
+		}
+		procs = append(procs, p)
+	}
+	return procs
+}
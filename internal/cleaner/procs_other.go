@@ -0,0 +1,10 @@
+//go:build !darwin && !linux
+
+package cleaner
+
+// listProcesses lists nothing on platforms without a real implementation, so
+// protect_builds never finds a build running there. cachegoat doesn't
+// schedule itself on them either.
+func listProcesses() []process {
+	return nil
+}
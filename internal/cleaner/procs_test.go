@@ -0,0 +1,71 @@
+package cleaner
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+func TestMatchCommand(t *testing.T) {
+	for _, tc := range []struct {
+		cmd  string
+		p    process
+		want bool
+	}{
+		{"go test", process{args: []string{"go", "test", "./..."}}, true},
+		{"go test", process{args: []string{"/usr/local/go/bin/go", "test", "-v", "./..."}}, true},
+		{"go test", process{exe: "/usr/local/go/bin/go", args: []string{"go1.22", "-C", "src", "test"}}, true},
+		{"go build", process{args: []string{"go", "-C", "build", "vet", "./..."}}, false},
+		{"go vet", process{args: []string{"go", "-C", "build", "vet", "./..."}}, true},
+		{"go build", process{args: []string{"go", "-C=build", "build"}}, true},
+		{"go mod", process{args: []string{"go", "mod", "download"}}, true},
+		{"go test", process{args: []string{"go", "vet", "./test"}}, false},
+		{"go test", process{args: []string{"vim", "go", "test"}}, false},
+		{"go test", process{args: []string{"go"}}, false},
+		{"go build", process{args: []string{"/home/me/bin/gobuild"}}, false},
+		{"golangci-lint", process{args: []string{"golangci-lint", "run"}}, true},
+		{"builder compile*", process{args: []string{"builder", "-C", "compilepkg"}}, true}, // -C is only go's
+		{"builder compile*", process{args: []string{"bazel-out/k8-opt-exec/bin/external/go_sdk/builder_reset/builder", "compilepkg", "-sdk", "x"}}, true},
+		{"/opt/go/bin/go build", process{args: []string{"/usr/local/go/bin/go", "build"}}, false},
+		{"/opt/*/bin/go build", process{args: []string{"/opt/go/bin/go", "build"}}, true},
+	} {
+		if got := matchCommand(tc.p, tc.cmd); got != tc.want {
+			t.Errorf("matchCommand(%q, %q) = %v, want %v", tc.p.args, tc.cmd, got, tc.want)
+		}
+	}
+}
+
+func TestFindBlocker(t *testing.T) {
+	procs := []process{
+		{pid: 300, ppid: 1, args: []string{"go", "test", "./pkg"}},
+		{pid: 10, ppid: 1, args: []string{"go", "run", "./cmd/cachegoat"}},
+		{pid: 20, ppid: 10, args: []string{"/tmp/go-build1/b001/exe/cachegoat"}},
+		{pid: 200, ppid: 1, args: []string{"vim", "go_test.go"}},
+		{pid: 400, ppid: 1, args: []string{"/home/me/go/bin/gopls", "serve"}},
+	}
+	cmds := config.DefaultProtectCommands
+	b := findBlocker(procs, 20, cmds)
+	if b == nil || b.PID != 300 || b.Command != "go test" || b.Args != "go test ./pkg" {
+		t.Errorf("findBlocker = %+v, want go test as PID 300, not the go run that started cachegoat", b)
+	}
+	if b := findBlocker(procs[1:], 20, cmds); b != nil {
+		t.Errorf("findBlocker = %+v, want nil: only cachegoat's own go run, an editor and an idle gopls", b)
+	}
+}
+
+func TestListProcesses(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("no process listing on " + runtime.GOOS)
+	}
+	for _, p := range listProcesses() {
+		if p.pid == os.Getpid() {
+			if p.ppid != os.Getppid() || len(p.args) == 0 {
+				t.Errorf("own process listed as %+v, want ppid %d and args", p, os.Getppid())
+			}
+			return
+		}
+	}
+	t.Errorf("own process %d not listed", os.Getpid())
+}
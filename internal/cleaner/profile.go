@@ -0,0 +1,263 @@
+package cleaner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// The markers delimiting the block cachegoat manages in a shell profile.
+// Everything between them belongs to cachegoat and is rewritten or removed
+// as a whole; everything outside them is left byte for byte.
+const (
+	profileBlockStart = "# >>> cachegoat >>>"
+	profileBlockEnd   = "# <<< cachegoat <<<"
+)
+
+// shellDialect is the syntax a shell profile sets environment variables in.
+type shellDialect int
+
+const (
+	dialectSh   shellDialect = iota // bash, zsh, ksh: export NAME='value'
+	dialectFish                     // set -gx NAME 'value'
+	dialectCsh                      // csh, tcsh: setenv NAME 'value'
+)
+
+// shellProfile is a startup file a shell reads, relative to the home
+// directory.
+type shellProfile struct {
+	shell   string
+	file    string
+	dialect shellDialect
+}
+
+// shellProfiles are the startup files cachegoat knows how to edit. The first
+// one listed for a shell is where its block goes when none exist yet.
+var shellProfiles = []shellProfile{
+	{"bash", ".bashrc", dialectSh},
+	{"bash", ".bash_profile", dialectSh},
+	{"bash", ".bash_login", dialectSh},
+	{"bash", ".profile", dialectSh},
+	{"zsh", ".zshenv", dialectSh},
+	{"zsh", ".zprofile", dialectSh},
+	{"zsh", ".zshrc", dialectSh},
+	{"ksh", ".kshrc", dialectSh},
+	{"fish", ".config/fish/config.fish", dialectFish},
+	{"tcsh", ".tcshrc", dialectCsh},
+	{"csh", ".cshrc", dialectCsh},
+}
+
+// setsCacheVar matches a line that sets GOCACHE or GOMODCACHE in any dialect.
+var setsCacheVar = regexp.MustCompile(`(?m)^\s*(export\s+|set\s+-\w+\s+|setenv\s+)?(GOCACHE|GOMODCACHE)\b`)
+
+// profileTargets returns the profiles a managed block belongs in: the login
+// shell's own profile, created if need be, plus every existing profile that
+// already has a block or sets a cache variable itself. The block goes at the
+// end of each, so it wins over any earlier setting there.
+func profileTargets(home, shell string) []shellProfile {
+	var targets []shellProfile
+	hasOwn := false
+	for _, p := range shellProfiles {
+		data, err := os.ReadFile(filepath.Join(home, p.file))
+		if err != nil {
+			continue
+		}
+		if p.shell == shell {
+			hasOwn = true
+		}
+		if strings.Contains(string(data), profileBlockStart) || setsCacheVar.Match(data) || p.shell == shell {
+			targets = append(targets, p)
+		}
+	}
+	if !hasOwn {
+		if p, ok := primaryProfile(shell); ok {
+			targets = append(targets, p)
+		}
+	}
+	return targets
+}
+
+// primaryProfile returns the profile to create for shell when it has none.
+// macOS terminals start bash as a login shell, which reads .bash_profile
+// rather than .bashrc.
+func primaryProfile(shell string) (shellProfile, bool) {
+	if shell == "bash" && runtime.GOOS == "darwin" {
+		return shellProfile{"bash", ".bash_profile", dialectSh}, true
+	}
+	for _, p := range shellProfiles {
+		if p.shell == shell {
+			return p, true
+		}
+	}
+	return shellProfile{}, false
+}
+
+// loginShell returns the name of the user's login shell, such as "zsh".
+func loginShell() string {
+	return filepath.Base(os.Getenv("SHELL"))
+}
+
+// profileBlock renders the managed block setting vars, in order, for dialect.
+func profileBlock(d shellDialect, vars [][2]string) string {
+	var b strings.Builder
+	b.WriteString(profileBlockStart + "\n")
+	b.WriteString("# Managed by cachegoat; remove with 'cachegoat --recommend --revert'.\n")
+	for _, kv := range vars {
+		switch d {
+		case dialectFish:
+			fmt.Fprintf(&b, "set -gx %s %s\n", kv[0], fishQuote(kv[1]))
+		case dialectCsh:
+			fmt.Fprintf(&b, "setenv %s %s\n", kv[0], shQuote(kv[1]))
+		default:
+			fmt.Fprintf(&b, "export %s=%s\n", kv[0], shQuote(kv[1]))
+		}
+	}
+	b.WriteString(profileBlockEnd + "\n")
+	return b.String()
+}
+
+// shQuote single-quotes s for sh and csh.
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote single-quotes s for fish, which escapes within single quotes.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// withProfileBlock returns content with its managed block replaced by block,
+// or block appended if it has none. An empty block removes the managed block,
+// along with the blank line that separated it. A block whose end marker is
+// missing is an error, as there's no telling where it ends.
+func withProfileBlock(content, block string) (string, error) {
+	start := strings.Index(content, profileBlockStart)
+	if start < 0 {
+		if block == "" {
+			return content, nil
+		}
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		if content != "" {
+			content += "\n"
+		}
+		return content + block, nil
+	}
+
+	i := strings.Index(content[start:], profileBlockEnd)
+	if i < 0 {
+		line := strings.Count(content[:start], "\n") + 1
+		return "", fmt.Errorf("the cachegoat block on line %d has no %q line to end it; fix or remove it by hand", line, profileBlockEnd)
+	}
+	end := start + i + len(profileBlockEnd)
+	if end < len(content) && content[end] == '\n' {
+		end++
+	}
+	before, after := content[:start], content[end:]
+	if block == "" && after == "" {
+		before = strings.TrimSuffix(before, "\n") // the separating blank line
+		if before != "" && !strings.HasSuffix(before, "\n") {
+			before += "\n"
+		}
+	}
+	return before + block + after, nil
+}
+
+// updateProfile sets the managed block in file to block, or removes it if
+// block is empty. A file that changes is first backed up alongside itself with
+// a timestamped name, and is rewritten in place so it keeps its mode (and
+// stays the target of any symlink pointing at it). It reports whether the file
+// changed, and the backup made, if any.
+func updateProfile(file, block string, now time.Time) (changed bool, backup string, err error) {
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return false, "", err
+	}
+	existed := err == nil
+	updated, err := withProfileBlock(string(data), block)
+	if err != nil {
+		return false, "", err
+	}
+	if updated == string(data) {
+		return false, "", nil
+	}
+
+	mode := os.FileMode(0644)
+	if existed {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, "", err
+		}
+		mode = info.Mode().Perm()
+		backup = file + ".cachegoat-" + now.Format("20060102-150405")
+		if err := os.WriteFile(backup, data, mode); err != nil {
+			return false, "", fmt.Errorf("failed to back up %s: %w", file, err)
+		}
+	} else if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return false, "", err
+	}
+
+	if err := os.WriteFile(file, []byte(updated), mode); err != nil {
+		return false, backup, err
+	}
+	return true, backup, nil
+}
+
+// setProfileVars writes a managed block setting vars into each target
+// profile under home, reporting each file it changed to w. It returns the
+// files changed.
+func setProfileVars(w io.Writer, home string, targets []shellProfile, vars [][2]string) ([]string, error) {
+	var changed []string
+	now := time.Now()
+	for _, p := range targets {
+		file := filepath.Join(home, p.file)
+		ok, backup, err := updateProfile(file, profileBlock(p.dialect, vars), now)
+		if err != nil {
+			return changed, fmt.Errorf("failed to update %s: %w", file, err)
+		}
+		if !ok {
+			_, _ = fmt.Fprintf(w, "✓ %s already up to date\n", file)
+			continue
+		}
+		changed = append(changed, file)
+		if backup != "" {
+			_, _ = fmt.Fprintf(w, "✅ Updated %s (backup: %s)\n", file, backup)
+		} else {
+			_, _ = fmt.Fprintf(w, "✅ Created %s\n", file)
+		}
+	}
+	return changed, nil
+}
+
+// RevertProfiles removes cachegoat's managed block from every shell profile
+// that has one, backing each up first, and reports what it did to w. It
+// returns the files changed.
+func RevertProfiles(w io.Writer) ([]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home dir: %w", err)
+	}
+	var changed []string
+	now := time.Now()
+	for _, p := range shellProfiles {
+		file := filepath.Join(home, p.file)
+		ok, backup, err := updateProfile(file, "", now)
+		if err != nil {
+			return changed, fmt.Errorf("failed to update %s: %w", file, err)
+		}
+		if ok {
+			changed = append(changed, file)
+			_, _ = fmt.Fprintf(w, "✅ Removed cachegoat block from %s (backup: %s)\n", file, backup)
+		}
+	}
+	if len(changed) == 0 {
+		_, _ = fmt.Fprintln(w, "✓ No cachegoat block found in any shell profile")
+	}
+	return changed, nil
+}
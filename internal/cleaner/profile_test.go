@@ -0,0 +1,129 @@
+package cleaner
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// TestProfileBlockGolden applies the managed block to a sample profile in each
+// shell dialect and compares the result with testdata/profile/<shell>.golden.
+// Run with -update to regenerate the golden files.
+func TestProfileBlockGolden(t *testing.T) {
+	for _, tc := range []struct {
+		shell   string
+		dialect shellDialect
+	}{
+		{"bash", dialectSh},
+		{"zsh", dialectSh},
+		{"fish", dialectFish},
+		{"csh", dialectCsh},
+	} {
+		t.Run(tc.shell, func(t *testing.T) {
+			in, err := os.ReadFile(filepath.Join("testdata", "profile", tc.shell+".in"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			block := profileBlock(tc.dialect, [][2]string{{"GOCACHE", "/tmp/go-cache"}, {"GOMODCACHE", "/tmp/it's here"}})
+			got, err := withProfileBlock(string(in), block)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "profile", tc.shell+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+
+			if again, _ := withProfileBlock(got, block); again != got {
+				t.Errorf("applying the block twice changed the file:\n%s", again)
+			}
+			if reverted, _ := withProfileBlock(got, ""); reverted != string(in) {
+				t.Errorf("revert did not restore the original:\n%q\nwant:\n%q", reverted, in)
+			}
+		})
+	}
+}
+
+func TestUpdateProfileBacksUpAndKeepsMode(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".bashrc")
+	orig := "export GOCACHE=~/old\n"
+	vars := [][2]string{{"GOCACHE", "/tmp/go-cache"}}
+	if err := os.WriteFile(file, []byte(orig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	changed, backup, err := updateProfile(file, profileBlock(dialectSh, vars), now)
+	if err != nil || !changed {
+		t.Fatalf("changed=%v err=%v", changed, err)
+	}
+	if backup != file+".cachegoat-20260102-030405" {
+		t.Errorf("backup = %s", backup)
+	}
+	if data, _ := os.ReadFile(backup); string(data) != orig {
+		t.Errorf("backup holds %q, want the original", data)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600 preserved", info.Mode().Perm())
+	}
+
+	if changed, _, _ := updateProfile(file, profileBlock(dialectSh, vars), now); changed {
+		t.Error("rewriting the same block should be a no-op")
+	}
+}
+
+// TestUpdateProfileRefusesUnterminatedBlock verifies a managed block missing
+// its end marker is reported, leaving the file alone rather than treating
+// everything after the start marker as part of the block.
+func TestUpdateProfileRefusesUnterminatedBlock(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".bashrc")
+	orig := "export PATH=$HOME/bin\n" + profileBlockStart + "\nexport GOCACHE='/tmp/go-cache'\nalias ll='ls -l'\n"
+	if err := os.WriteFile(file, []byte(orig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range []string{"", profileBlock(dialectSh, [][2]string{{"GOCACHE", "/tmp/go-cache"}})} {
+		changed, backup, err := updateProfile(file, block, time.Now())
+		if err == nil || !strings.Contains(err.Error(), "line 2") || changed || backup != "" {
+			t.Errorf("changed=%v backup=%q err=%v, want a refusal naming line 2", changed, backup, err)
+		}
+		if data, _ := os.ReadFile(file); string(data) != orig {
+			t.Errorf("file changed to %q", data)
+		}
+	}
+}
+
+func TestProfileTargets(t *testing.T) {
+	home := t.TempDir()
+	for name, content := range map[string]string{
+		".zshrc":   "export GOCACHE=~/old\n",  // sets a cache var: must be overridden
+		".profile": "export PATH=$HOME/bin\n", // unrelated: left alone
+	} {
+		if err := os.WriteFile(filepath.Join(home, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for _, p := range profileTargets(home, "fish") {
+		got = append(got, p.file)
+	}
+	want := []string{".zshrc", ".config/fish/config.fish"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("targets = %v, want %v", got, want)
+	}
+}
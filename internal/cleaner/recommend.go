@@ -2,106 +2,288 @@ package cleaner
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/YakDriver/cachegoat/internal/config"
 )
 
-func Recommend(cfg *config.Config) {
-	fmt.Println("cachegoat recommendations:")
-	fmt.Println()
-
-	// OS info
-	fmt.Printf("System: %s/%s\n\n", runtime.GOOS, runtime.GOARCH)
-
-	// Check for CrowdStrike
-	if hasCrowdStrike() {
-		fmt.Println("⚠️  CrowdStrike detected")
-		needsUpdate := false
-		if !strings.HasPrefix(cfg.BuildCache.Path, "/tmp") {
-			fmt.Printf("   → Consider moving build cache to /tmp to avoid scanning\n")
-			fmt.Printf("     Current: %s\n", cfg.BuildCache.Path)
-			needsUpdate = true
-		} else {
-			fmt.Println("   ✓ Build cache already in /tmp (good)")
+// IDs of the findings Recommend reports, as accepted by --apply.
+const (
+	FindingRelocate        = "relocate"         // caches sit outside relocate_to under CrowdStrike
+	FindingOrphans         = "orphans"          // old cache locations Go no longer uses still take up space
+	FindingThresholds      = "thresholds"       // a large cache's max_size is too high
+	FindingSchedule        = "schedule"         // no scheduled cleanup is set up
+	FindingScheduledBinary = "scheduled-binary" // the schedule runs a different cachegoat
+)
+
+// FindingIDs lists every finding ID, in the order Recommend checks them.
+var FindingIDs = []string{FindingRelocate, FindingOrphans, FindingThresholds, FindingSchedule, FindingScheduledBinary}
+
+// sizeAdvice is when a cache counts as large, and the max_size Recommend
+// suggests for it.
+var sizeAdvice = map[string]struct{ warnAt, suggest int64 }{
+	"build": {warnAt: 50 * bytesPerGB, suggest: 30 * bytesPerGB},
+	"mod":   {warnAt: 5 * bytesPerGB, suggest: 5 * bytesPerGB},
+}
+
+// Recommendations is what Recommend examines and advises, as data.
+type Recommendations struct {
+	System          string        `json:"system"`
+	CrowdStrike     bool          `json:"crowdstrike"`
+	RelocateTo      string        `json:"relocate_to"`
+	BuildCache      CacheSummary  `json:"build_cache"`
+	ModCache        CacheSummary  `json:"mod_cache"`
+	Orphans         []OrphanCache `json:"orphans"`
+	Scheduled       bool          `json:"scheduled"`
+	ScheduledBinary string        `json:"scheduled_binary,omitempty"`
+	CurrentBinary   string        `json:"current_binary,omitempty"`
+	Findings        []*Finding    `json:"findings"`
+}
+
+// CacheSummary is a cache's location and the disk space it occupies.
+type CacheSummary struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// Finding is one problem Recommend found, with what to do about it. Several
+// findings may share an ID, such as a threshold for each cache; applying an
+// ID fixes them all.
+type Finding struct {
+	ID       string `json:"id"`
+	Problem  string `json:"problem"`
+	Advice   string `json:"advice"`
+	Fixable  bool   `json:"fixable"`
+	Resolved bool   `json:"resolved"`
+	Error    string `json:"error,omitempty"` // why the fix failed
+
+	fix func(w io.Writer) error
+}
+
+// RecommendOptions controls which findings Recommend fixes.
+type RecommendOptions struct {
+	Apply []string  // IDs of findings to fix without asking
+	Yes   bool      // fix every fixable finding without asking
+	In    io.Reader // answers to y/N prompts for the rest; nil never prompts
+	Out   io.Writer // where the report and the fixes' progress go
+}
+
+func (o RecommendOptions) preapproved(id string) bool {
+	return o.Yes || slices.Contains(o.Apply, id)
+}
+
+// Analyze runs Recommend's checks without printing, prompting or fixing
+// anything.
+func Analyze(cfg *config.Config) *Recommendations {
+	r := &Recommendations{
+		System:      runtime.GOOS + "/" + runtime.GOARCH,
+		CrowdStrike: hasCrowdStrike(),
+		RelocateTo:  cfg.RelocateRoot(),
+		BuildCache:  CacheSummary{Path: cfg.BuildCache.Path, SizeBytes: dirSize(cfg.BuildCache.Path)},
+		ModCache:    CacheSummary{Path: cfg.ModCache.Path, SizeBytes: dirSize(cfg.ModCache.Path)},
+		Orphans:     findOrphans(cfg),
+		Scheduled:   hasScheduledCleanup(),
+		Findings:    []*Finding{},
+	}
+	add := func(id, problem, advice string, fix func(io.Writer) error) {
+		r.Findings = append(r.Findings, &Finding{ID: id, Problem: problem, Advice: advice, Fixable: fix != nil, fix: fix})
+	}
+
+	if r.CrowdStrike {
+		// The profile block sets both variables, so a cache already on the
+		// target keeps its path there rather than being moved again.
+		home, _ := os.UserHomeDir()
+		build, mod := cfg.RelocatedPaths()
+		var outside []string
+		var vars [][2]string
+		var moves []*Migration
+		for _, c := range []struct{ name, env, path, dest string }{
+			{"build", "GOCACHE", r.BuildCache.Path, build},
+			{"mod", "GOMODCACHE", r.ModCache.Path, mod},
+		} {
+			if onRelocateTarget(c.path, r.RelocateTo, home) {
+				vars = append(vars, [2]string{c.env, c.path})
+				continue
+			}
+			outside = append(outside, fmt.Sprintf("%s cache (%s)", c.name, c.path))
+			vars = append(vars, [2]string{c.env, c.dest})
+			if c.path != "" {
+				moves = append(moves, &Migration{Name: c.name, From: c.path, To: c.dest})
+			}
 		}
-		if !strings.HasPrefix(cfg.ModCache.Path, "/tmp") {
-			fmt.Printf("   → Consider moving mod cache to /tmp\n")
-			fmt.Printf("     Current: %s\n", cfg.ModCache.Path)
-			needsUpdate = true
-		} else {
-			fmt.Println("   ✓ Mod cache already in /tmp (good)")
+		if len(outside) > 0 {
+			dir := cfg.RelocateDir()
+			add(FindingRelocate, fmt.Sprintf("CrowdStrike scans caches outside %s: %s", r.RelocateTo, strings.Join(outside, ", ")),
+				fmt.Sprintf("Move the caches to %s to avoid scanning, updating GOCACHE and GOMODCACHE in your shell profile", dir),
+				func(w io.Writer) error {
+					if err := applyCacheRecommendations(w, dir, vars); err != nil {
+						return err
+					}
+					// Remember where the contents are, since Go won't once the
+					// new shell profile takes effect.
+					if err := recordMigrations(cfg, moves); err != nil {
+						return fmt.Errorf("failed to save migration state: %w", err)
+					}
+					_, _ = fmt.Fprintln(w, "💡 Run 'cachegoat --migrate' to bring the existing cache contents along")
+					return nil
+				})
 		}
+	}
 
-		if needsUpdate {
-			fmt.Print("\n❓ Apply CrowdStrike recommendations, including updating env vars in your shell profile? (y/N): ")
-			var response string
-			_, _ = fmt.Scanln(&response)
-			if strings.ToLower(response) == "y" || strings.ToLower(response) == "yes" {
-				applyCacheRecommendations()
-			}
+	if len(r.Orphans) > 0 {
+		var total int64
+		var list []string
+		for _, o := range r.Orphans {
+			total += o.SizeBytes
+			list = append(list, fmt.Sprintf("%s (%s cache, %.1fGB)", o.Path, o.Name, toGB(o.SizeBytes)))
 		}
-		fmt.Println()
-	} else {
-		fmt.Println("✓ No CrowdStrike detected")
-		fmt.Println()
+		orphans := r.Orphans
+		add(FindingOrphans,
+			fmt.Sprintf("Old cache locations Go no longer uses hold %.1fGB: %s", toGB(total), strings.Join(list, ", ")),
+			"Delete them",
+			func(w io.Writer) error { return purgeOrphans(w, cfg, orphans) })
 	}
 
-	// Check cache sizes
-	buildSize := dirSizeGB(cfg.BuildCache.Path)
-	modSize := dirSizeGB(cfg.ModCache.Path)
+	for _, c := range []struct {
+		name, key string
+		cc        config.CacheConfig
+		size      int64
+	}{
+		{"build", "build_cache", cfg.BuildCache, r.BuildCache.SizeBytes},
+		{"mod", "mod_cache", cfg.ModCache, r.ModCache.SizeBytes},
+	} {
+		advice := sizeAdvice[c.name]
+		total, _, _ := diskSpace(c.cc.Path)
+		high, _ := c.cc.Limits(total)
+		if c.size <= advice.warnAt || high < 0 || high <= advice.suggest {
+			continue
+		}
+		suggest := config.Size{Bytes: advice.suggest}
+		key := c.key + ".max_size"
+		add(FindingThresholds,
+			fmt.Sprintf("%s cache is large (%.1fGB)", strings.ToUpper(c.name[:1])+c.name[1:], toGB(c.size)),
+			fmt.Sprintf("Lower %s to %s (currently %s)", key, suggest, c.cc.High()),
+			func(w io.Writer) error {
+				file := config.File()
+				if err := config.SetFileValue(file, key, suggest.String()); err != nil {
+					return err
+				}
+				_, _ = fmt.Fprintf(w, "✅ Set %s: %s in %s\n", key, suggest, file)
+				return nil
+			})
+	}
 
-	if buildSize > 50 {
-		fmt.Printf("⚠️  Build cache is large (%.1fGB)\n", buildSize)
-		fmt.Printf("   → Consider lowering max_size_gb threshold (currently %d)\n\n", cfg.BuildCache.MaxSizeGB)
+	if !r.Scheduled {
+		add(FindingSchedule, "No scheduled cleanup detected", "Set up automatic scheduled cleanup", Schedule)
+	} else {
+		r.ScheduledBinary, r.CurrentBinary = scheduledBinary(), currentBinary()
+		if r.ScheduledBinary != "" && r.CurrentBinary != "" && r.ScheduledBinary != r.CurrentBinary {
+			add(FindingScheduledBinary,
+				fmt.Sprintf("Scheduled cleanup runs a different binary than the cachegoat on your PATH (scheduled: %s, on PATH: %s)", r.ScheduledBinary, r.CurrentBinary),
+				"Re-schedule cleanup to point it at the current binary",
+				func(w io.Writer) error {
+					if err := Unschedule(w); err != nil {
+						return err
+					}
+					return Schedule(w)
+				})
+		}
 	}
+	return r
+}
+
+// Unresolved returns the findings still standing.
+func (r *Recommendations) Unresolved() []*Finding {
+	var out []*Finding
+	for _, f := range r.Findings {
+		if !f.Resolved {
+			out = append(out, f)
+		}
+	}
+	return out
+}
 
-	if modSize > 5 {
-		fmt.Printf("⚠️  Mod cache is %.1fGB\n", modSize)
-		fmt.Printf("   → Run 'go clean -modcache' if you have stale dependencies\n\n")
+// Recommend checks the setup, reports what it found to opts.Out, and fixes the
+// findings opts approves, asking about the rest when it can. It returns the
+// findings along with how each was resolved.
+func Recommend(cfg *config.Config, opts RecommendOptions) *Recommendations {
+	r := Analyze(cfg)
+	r.resolve(opts)
+	_, _ = fmt.Fprintln(opts.Out, "\nCurrent config:")
+	_, _ = fmt.Fprint(opts.Out, cfg.String())
+	return r
+}
+
+// resolve reports the findings and applies the approved fixes.
+func (r *Recommendations) resolve(opts RecommendOptions) {
+	w := opts.Out
+	_, _ = fmt.Fprintf(w, "cachegoat recommendations:\n\n")
+	_, _ = fmt.Fprintf(w, "System: %s\n", r.System)
+	if r.CrowdStrike {
+		_, _ = fmt.Fprintln(w, "⚠️  CrowdStrike detected")
+	} else {
+		_, _ = fmt.Fprintln(w, "✓ No CrowdStrike detected")
+	}
+	if r.Scheduled {
+		_, _ = fmt.Fprintln(w, "✓ Scheduled cleanup detected")
 	}
 
-	// Check scheduling
-	if !hasScheduledCleanup() {
-		fmt.Println("\n⚠️  No scheduled cleanup detected")
-		fmt.Print("❓ Set up automatic scheduled cleanup? (y/N): ")
-		var response string
-		_, _ = fmt.Scanln(&response)
-		if strings.ToLower(response) == "y" || strings.ToLower(response) == "yes" {
-			if err := Schedule(); err != nil {
-				fmt.Printf("❌ Failed to schedule cleanup: %v\n", err)
-			} else {
-				fmt.Println("✅ Scheduled cleanup configured successfully!")
+	if len(r.Findings) == 0 {
+		_, _ = fmt.Fprintln(w, "\n✓ Nothing to fix")
+		return
+	}
+	for _, f := range r.Findings {
+		_, _ = fmt.Fprintf(w, "\n⚠️  [%s] %s\n", f.ID, f.Problem)
+		_, _ = fmt.Fprintf(w, "   → %s\n", f.Advice)
+		if !f.Fixable {
+			continue
+		}
+		if !opts.preapproved(f.ID) && !confirm(opts.In, w, "❓ Apply this fix? (y/N): ") {
+			if f.ID == FindingSchedule {
+				_, _ = fmt.Fprintln(w, "\nManual setup instructions:")
+				printScheduleInstructions(w)
 			}
-		} else {
-			fmt.Println("\nManual setup instructions:")
-			printScheduleInstructions()
+			continue
 		}
-	} else {
-		fmt.Println("\n✓ Scheduled cleanup detected")
-		if sched, cur := scheduledBinary(), currentBinary(); sched != "" && cur != "" && sched != cur {
-			fmt.Printf("⚠️  Scheduled cleanup runs a different binary than the cachegoat on your PATH:\n")
-			fmt.Printf("     scheduled: %s\n", sched)
-			fmt.Printf("     on PATH:   %s\n", cur)
-			fmt.Printf("   → Re-run 'cachegoat --unschedule && cachegoat --schedule' to point it at the current binary.\n")
+		if err := f.fix(w); err != nil {
+			f.Error = err.Error()
+			_, _ = fmt.Fprintf(w, "❌ Failed to apply %s: %v\n", f.ID, err)
+			continue
 		}
+		f.Resolved = true
 	}
 
-	fmt.Println("\nCurrent config:")
-	fmt.Print(cfg.String())
+	if n := len(r.Unresolved()); n > 0 {
+		_, _ = fmt.Fprintf(w, "\n%d finding(s) unresolved; fix them with --apply=<id>[,<id>...] or --yes\n", n)
+	}
+}
+
+// confirm asks a y/N question on w and reads the answer from in. With no in,
+// or no answer, the answer is no.
+func confirm(in io.Reader, w io.Writer, question string) bool {
+	if in == nil {
+		return false
+	}
+	_, _ = fmt.Fprint(w, question)
+	var response string
+	_, _ = fmt.Fscanln(in, &response)
+	response = strings.ToLower(response)
+	return response == "y" || response == "yes"
 }
 
-func printScheduleInstructions() {
+func printScheduleInstructions(w io.Writer) {
 	bin := findBinary()
 	switch runtime.GOOS {
 	case "darwin":
-		fmt.Println("   → Create ~/Library/LaunchAgents/com.cachegoat.plist:")
-		fmt.Printf(`
+		_, _ = fmt.Fprintln(w, "   → Create ~/Library/LaunchAgents/com.cachegoat.plist:")
+		_, _ = fmt.Fprintf(w, `
 <?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
 <plist version="1.0">
@@ -121,8 +303,8 @@ func printScheduleInstructions() {
 `, bin)
 	case "linux":
 		if hasSystemd() {
-			fmt.Println("   → systemd detected, create ~/.config/systemd/user/cachegoat.service:")
-			fmt.Printf(`
+			_, _ = fmt.Fprintln(w, "   → systemd detected, create ~/.config/systemd/user/cachegoat.service:")
+			_, _ = fmt.Fprintf(w, `
 [Unit]
 Description=Go cache cleanup
 
@@ -144,11 +326,11 @@ WantedBy=timers.target
    Then run: systemctl --user enable --now cachegoat.timer
 `, bin)
 		} else {
-			fmt.Println("   → Add to crontab (crontab -e):")
-			fmt.Printf("   0 */2 * * * %s\n", bin)
+			_, _ = fmt.Fprintln(w, "   → Add to crontab (crontab -e):")
+			_, _ = fmt.Fprintf(w, "   0 */2 * * * %s\n", bin)
 		}
 	default:
-		fmt.Println("   → Add to your system scheduler to run every 2 hours")
+		_, _ = fmt.Fprintln(w, "   → Add to your system scheduler to run every 2 hours")
 	}
 }
 
@@ -259,110 +441,42 @@ func hasScheduledCleanup() bool {
 	return strings.Contains(string(out), "cachegoat")
 }
 
-func applyCacheRecommendations() {
-	fmt.Println("\n🔧 Applying cache recommendations...")
-
-	// Find shell profile files and update them
-	updated := false
-
-	// Check common profile files
-	home, _ := os.UserHomeDir()
-	profileFiles := []string{
-		filepath.Join(home, ".bashrc"),
-		filepath.Join(home, ".zshrc"),
-		filepath.Join(home, ".profile"),
-		filepath.Join(home, ".bash_profile"),
-		filepath.Join(home, ".zprofile"),                // zsh login profile
-		filepath.Join(home, ".zshenv"),                  // zsh environment (always sourced)
-		filepath.Join(home, ".bash_login"),              // bash login alternative
-		filepath.Join(home, ".config/fish/config.fish"), // fish shell
-		filepath.Join(home, ".tcshrc"),                  // tcsh/csh
-		filepath.Join(home, ".cshrc"),                   // csh
-		filepath.Join(home, ".kshrc"),                   // korn shell
-	}
-
-	for _, profileFile := range profileFiles {
-		if updateProfileFile(profileFile) {
-			updated = true
-		}
-	}
-
-	if !updated {
-		// Fallback to go env -w if no profile files were updated
-		if err := exec.Command("go", "env", "-w", "GOCACHE=/tmp/go-cache").Run(); err != nil {
-			fmt.Printf("❌ Failed to set GOCACHE: %v\n", err)
-			return
-		}
+// applyCacheRecommendations creates dir, the user's private relocation
+// directory, and sets vars, the cache locations within it, in a managed block
+// in the shell profiles (see profileTargets), or with `go env -w` when the
+// login shell is not one cachegoat can edit.
+func applyCacheRecommendations(w io.Writer, dir string, vars [][2]string) error {
+	_, _ = fmt.Fprintln(w, "\n🔧 Applying cache recommendations...")
 
-		if err := exec.Command("go", "env", "-w", "GOMODCACHE=/tmp/go-mod-cache").Run(); err != nil {
-			fmt.Printf("❌ Failed to set GOMODCACHE: %v\n", err)
-			return
-		}
-
-		fmt.Println("✅ Cache paths set using 'go env -w'")
-		fmt.Println("   → GOCACHE=/tmp/go-cache")
-		fmt.Println("   → GOMODCACHE=/tmp/go-mod-cache")
-	}
-
-	fmt.Println("\n💡 Restart your shell or run 'source <profile-file>' to apply changes")
-}
-
-func updateProfileFile(profileFile string) bool {
-	data, err := os.ReadFile(profileFile)
+	home, err := os.UserHomeDir()
 	if err != nil {
-		return false // File doesn't exist or can't read
+		return fmt.Errorf("failed to get home dir: %w", err)
 	}
-
-	content := string(data)
-	updated := false
-	isFish := strings.Contains(profileFile, "fish")
-
-	// Update GOCACHE if found
-	if strings.Contains(content, "GOCACHE") {
-		var re *regexp.Regexp
-		var replacement string
-
-		if isFish {
-			re = regexp.MustCompile(`set -x GOCACHE .*`)
-			replacement = "set -x GOCACHE /tmp/go-cache"
-		} else {
-			re = regexp.MustCompile(`export\s+GOCACHE=.*`)
-			replacement = "export GOCACHE=/tmp/go-cache"
-		}
-
-		if re.MatchString(content) {
-			content = re.ReplaceAllString(content, replacement)
-			updated = true
-			fmt.Printf("✅ Updated GOCACHE in %s\n", profileFile)
-		}
+	// The relocation target is often shared, like /tmp, so the user's
+	// directory must be theirs alone before caches go into it.
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
 	}
-
-	// Update GOMODCACHE if found
-	if strings.Contains(content, "GOMODCACHE") {
-		var re *regexp.Regexp
-		var replacement string
-
-		if isFish {
-			re = regexp.MustCompile(`set -x GOMODCACHE .*`)
-			replacement = "set -x GOMODCACHE /tmp/go-mod-cache"
-		} else {
-			re = regexp.MustCompile(`export\s+GOMODCACHE=.*`)
-			replacement = "export GOMODCACHE=/tmp/go-mod-cache"
+	if info, err := os.Lstat(dir); err != nil || !info.IsDir() || !ownedByCurrentUser(info) {
+		return fmt.Errorf("%s is not a directory owned by the current user", dir)
+	}
+	targets := profileTargets(home, loginShell())
+	if len(targets) == 0 {
+		for _, kv := range vars {
+			if err := exec.Command("go", "env", "-w", kv[0]+"="+kv[1]).Run(); err != nil {
+				return fmt.Errorf("failed to set %s: %w", kv[0], err)
+			}
 		}
-
-		if re.MatchString(content) {
-			content = re.ReplaceAllString(content, replacement)
-			updated = true
-			fmt.Printf("✅ Updated GOMODCACHE in %s\n", profileFile)
+		_, _ = fmt.Fprintln(w, "✅ Cache paths set using 'go env -w'")
+		for _, kv := range vars {
+			_, _ = fmt.Fprintf(w, "   → %s=%s\n", kv[0], kv[1])
 		}
+		return nil
 	}
 
-	if updated {
-		if err := os.WriteFile(profileFile, []byte(content), 0644); err != nil {
-			fmt.Printf("❌ Failed to write %s: %v\n", profileFile, err)
-			return false
-		}
+	if _, err := setProfileVars(w, home, targets, vars); err != nil {
+		return err
 	}
-
-	return updated
+	_, _ = fmt.Fprintln(w, "\n💡 Restart your shell or run 'source <profile-file>' to apply changes")
+	return nil
 }
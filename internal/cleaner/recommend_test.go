@@ -0,0 +1,82 @@
+package cleaner
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fakeFindings returns findings whose fixes record which IDs ran, with the
+// thresholds fix failing.
+func fakeFindings(ran *[]string) *Recommendations {
+	fix := func(id string, err error) func(io.Writer) error {
+		return func(io.Writer) error {
+			*ran = append(*ran, id)
+			return err
+		}
+	}
+	r := &Recommendations{System: "test/test"}
+	for _, f := range []struct {
+		id  string
+		err error
+	}{
+		{FindingRelocate, nil},
+		{FindingThresholds, errors.New("read-only config")},
+		{FindingSchedule, nil},
+	} {
+		r.Findings = append(r.Findings, &Finding{ID: f.id, Problem: f.id + " problem", Fixable: true, fix: fix(f.id, f.err)})
+	}
+	r.Findings = append(r.Findings, &Finding{ID: FindingScheduledBinary, Problem: "informational"})
+	return r
+}
+
+func TestResolveApplySelected(t *testing.T) {
+	var ran []string
+	r := fakeFindings(&ran)
+	var out bytes.Buffer
+	r.resolve(RecommendOptions{Apply: []string{FindingRelocate, FindingThresholds}, Out: &out})
+
+	if strings.Join(ran, ",") != "relocate,thresholds" {
+		t.Errorf("ran %v, want only the selected fixes", ran)
+	}
+	if !r.Findings[0].Resolved {
+		t.Error("relocate should be resolved")
+	}
+	if f := r.Findings[1]; f.Resolved || f.Error != "read-only config" {
+		t.Errorf("failed fix: resolved=%v error=%q", f.Resolved, f.Error)
+	}
+	if n := len(r.Unresolved()); n != 3 {
+		t.Errorf("%d unresolved, want 3 (failed, unselected, unfixable)", n)
+	}
+	if strings.Contains(out.String(), "❓") {
+		t.Errorf("must not prompt without input:\n%s", out.String())
+	}
+}
+
+func TestResolveYes(t *testing.T) {
+	var ran []string
+	r := fakeFindings(&ran)
+	r.resolve(RecommendOptions{Yes: true, Out: io.Discard})
+
+	if len(ran) != 3 {
+		t.Errorf("ran %v, want every fixable finding", ran)
+	}
+	for _, f := range r.Unresolved() {
+		if f.ID != FindingThresholds && f.ID != FindingScheduledBinary {
+			t.Errorf("%s should be resolved", f.ID)
+		}
+	}
+}
+
+func TestResolvePrompts(t *testing.T) {
+	var ran []string
+	r := fakeFindings(&ran)
+	r.resolve(RecommendOptions{In: strings.NewReader("y\nn\n"), Out: io.Discard})
+
+	// Answers go to relocate (y) and thresholds (n); schedule reads EOF.
+	if strings.Join(ran, ",") != "relocate" {
+		t.Errorf("ran %v, want only the confirmed fix", ran)
+	}
+}
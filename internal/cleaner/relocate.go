@@ -0,0 +1,84 @@
+package cleaner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// existingAncestor returns path itself if it exists, or else its nearest
+// ancestor that does.
+func existingAncestor(path string) (string, bool) {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", false
+		}
+		path = parent
+	}
+}
+
+// resolvePath returns path made absolute, with symlinks resolved as far as it
+// exists, so that, say, /tmp and /private/tmp on macOS compare equal.
+func resolvePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	dir, ok := existingAncestor(abs)
+	if !ok {
+		return abs
+	}
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return abs
+	}
+	rest, _ := filepath.Rel(dir, abs)
+	return filepath.Join(real, rest)
+}
+
+// within reports whether path is dir or lies inside it. Both must be clean
+// and absolute.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// onRelocateTarget reports whether the cache at path already sits where
+// relocating it would put it: inside the relocation directory target, or
+// anywhere else on target's filesystem when that is a mount of its own, such
+// as a tmpfs or a scratch disk the antivirus excludes as a whole. A filesystem
+// that also holds the home directory doesn't count as one of its own.
+func onRelocateTarget(path, target, home string) bool {
+	if path == "" || target == "" {
+		return false
+	}
+	p, t := resolvePath(path), resolvePath(target)
+	if within(p, t) {
+		return true
+	}
+	if home == "" {
+		return false
+	}
+	dev, ok := deviceOf(t)
+	if !ok {
+		return false
+	}
+	if homeDev, ok := deviceOf(home); !ok || homeDev == dev {
+		return false
+	}
+	pathDev, ok := deviceOf(p)
+	return ok && pathDev == dev
+}
+
+// underTempCleaner reports whether the cache at path lives somewhere an OS
+// temp cleaner may prune: on the relocation target, or in the system temp
+// directory.
+func (c *Cleaner) underTempCleaner(path string) bool {
+	home, _ := os.UserHomeDir()
+	return onRelocateTarget(path, c.cfg.RelocateRoot(), home) ||
+		within(resolvePath(path), resolvePath(os.TempDir()))
+}
@@ -0,0 +1,37 @@
+package cleaner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOnRelocateTarget(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "scratch")
+	if err := os.MkdirAll(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+
+	for _, tc := range []struct {
+		name, path string
+		want       bool
+	}{
+		{"inside", filepath.Join(target, "alice", "go-cache"), true},
+		{"target itself", target, true},
+		{"through a symlink", filepath.Join(link, "go-cache"), true},
+		// A sibling on the same filesystem as home is not on a mount of its own.
+		{"same filesystem as home", filepath.Join(root, "home", "go-cache"), false},
+		{"unknown", "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := onRelocateTarget(tc.path, target, filepath.Join(root, "home")); got != tc.want {
+				t.Errorf("onRelocateTarget(%q) = %v, want %v", tc.path, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package cleaner
+
+import "fmt"
+
+// Cleanup actions a run can take on a cache, as listed in CacheReport.Actions.
+const (
+	ActionPrune  = "prune"  // keep_versions evicted older module versions
+	ActionExpire = "expire" // max_age removed entries unused for too long
+	ActionTrim   = "trim"   // least-recently-used entries evicted down to a target
+	ActionPurge  = "purge"  // the whole cache wiped, with `go clean` for Go's own
+)
+
+// Report is a machine-readable account of a run, for tooling that would
+// otherwise scrape the log. In dry-run mode it describes what would happen.
+type Report struct {
+	DryRun    bool           `json:"dry_run"`
+	Skipped   string         `json:"skipped,omitempty"`    // why cleanup was skipped for every cache
+	BlockedBy *Blocker       `json:"blocked_by,omitempty"` // the build that cleanup was skipped for
+	Deferrals int            `json:"deferrals,omitempty"`  // how many runs in a row have skipped cleanup for a build
+	Caches    []*CacheReport `json:"caches"`
+	Errors    []string       `json:"errors,omitempty"`
+}
+
+// CacheReport describes what a run found and did in one cache. Sizes are in
+// bytes; SizeBytes is in the cache's configured measure, and ThresholdBytes
+// and FreeBytes are -1 when they can't be determined.
+type CacheReport struct {
+	Name           string   `json:"name"`
+	Profile        string   `json:"profile,omitempty"` // set when the config has profiles
+	Path           string   `json:"path"`
+	Measure        string   `json:"measure"`
+	SizeBytes      int64    `json:"size_bytes"`
+	AllocatedBytes int64    `json:"allocated_bytes"`
+	ApparentBytes  int64    `json:"apparent_bytes"`
+	ThresholdBytes int64    `json:"threshold_bytes"`
+	TargetBytes    int64    `json:"target_bytes,omitempty"`
+	FreeBytes      int64    `json:"free_bytes"`
+	Actions        []string `json:"actions"`
+	Reason         string   `json:"reason,omitempty"`
+	Removed        int      `json:"removed"`
+	ReclaimedBytes int64    `json:"reclaimed_bytes"`
+	Warmed         int      `json:"warmed"`
+	WarmScanned    int      `json:"warm_scanned"`
+	Skipped        string   `json:"skipped,omitempty"` // why the cache was left alone
+	Errors         []string `json:"errors,omitempty"`
+}
+
+// Report returns the account of the last Run.
+func (c *Cleaner) Report() *Report {
+	return &c.report
+}
+
+// cacheReport returns the report for the cache at path, adding it on first
+// use.
+func (c *Cleaner) cacheReport(path string) *CacheReport {
+	for _, cr := range c.report.Caches {
+		if cr.Path == path {
+			return cr
+		}
+	}
+	cr := &CacheReport{Path: path, Actions: []string{}, ThresholdBytes: -1, FreeBytes: -1}
+	if n, ok := c.named(path); ok {
+		cr.Name = n.Name
+	}
+	cr.Profile = c.profile
+	c.report.Caches = append(c.report.Caches, cr)
+	return cr
+}
+
+// record accounts a cleanup action that removed n entries, reclaiming bytes,
+// towards the cache at path. Actions that removed nothing are left out.
+func (c *Cleaner) record(path, action string, n int, bytes int64) {
+	if n == 0 {
+		return
+	}
+	cr := c.cacheReport(path)
+	cr.Actions = append(cr.Actions, action)
+	cr.Removed += n
+	cr.ReclaimedBytes += bytes
+}
+
+// failf logs a failure in the cache at path and records it in the report. An
+// empty path records it against the run as a whole.
+func (c *Cleaner) failf(path, format string, args ...any) {
+	c.logf(format, args...)
+	msg := fmt.Sprintf(format, args...)
+	if path == "" {
+		c.report.Errors = append(c.report.Errors, msg)
+		return
+	}
+	cr := c.cacheReport(path)
+	cr.Errors = append(cr.Errors, msg)
+}
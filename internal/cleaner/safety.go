@@ -0,0 +1,89 @@
+package cleaner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+// checkCacheDir returns why the directory at path must not be touched as the
+// given kind of cache, or nil if it's safe to. Whatever the configuration
+// says, cachegoat never cleans the root or home directory, nor a directory
+// without the marks Go leaves in a cache of that kind: the README or trim.txt
+// of a build cache, or a module cache's cache/download. A path that doesn't
+// exist yet holds nothing to clean, so it passes.
+func checkCacheDir(kind, path string) error {
+	real := resolvePath(path)
+	if real == filepath.Dir(real) {
+		return fmt.Errorf("%s is the root directory", path)
+	}
+	if home, err := os.UserHomeDir(); err == nil && real == resolvePath(home) {
+		return fmt.Errorf("%s is your home directory", path)
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	switch kind {
+	case config.KindBuild:
+		if !isFile(filepath.Join(path, "README")) && !isFile(filepath.Join(path, "trim.txt")) {
+			return fmt.Errorf("%s doesn't look like a Go build cache (no README or trim.txt)", path)
+		}
+	case config.KindMod:
+		if info, err := os.Stat(filepath.Join(path, "cache", "download")); err != nil || !info.IsDir() {
+			return fmt.Errorf("%s doesn't look like a Go module cache (no cache/download)", path)
+		}
+	}
+	return nil
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// safeToClean reports whether the cache at path may be touched, checking it
+// once per run. A refusal is logged and reported as an error against the
+// cache, which is then left alone: no expiry, trimming, purging or keep-warm.
+func (c *Cleaner) safeToClean(path string) bool {
+	if err, ok := c.refused[path]; ok {
+		return err == nil
+	}
+	n, _ := c.named(path)
+	err := checkCacheDir(n.Kind, path)
+	c.refused[path] = err
+	if err != nil {
+		c.failf(path, "refusing to clean %s: %v", path, err)
+		c.cacheReport(path).Skipped = err.Error()
+	}
+	return err == nil
+}
+
+// goClean runs go clean with flag against the cache at path, by pointing
+// envVar (GOCACHE or GOMODCACHE) at it, so Go empties the directory that was
+// measured rather than whatever its own configuration resolves to. As a last
+// check, Go must agree that's the cache it would clean.
+func goClean(flag, envVar, path string) error {
+	env := append(os.Environ(), envVar+"="+path)
+	cmd := exec.Command("go", "env", envVar)
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("go env %s failed: %w", envVar, err)
+	}
+	if got := strings.TrimSpace(string(out)); resolvePath(got) != resolvePath(path) {
+		return fmt.Errorf("go would clean %s, not %s", got, path)
+	}
+	cmd = exec.Command("go", "clean", flag)
+	cmd.Env = env
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("go clean %s failed: %w: %s", flag, err, msg)
+		}
+		return fmt.Errorf("go clean %s failed: %w", flag, err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package cleaner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+// scanWorkers bounds how many directories a scan reads at once. Scanning is
+// dominated by metadata I/O (and, under endpoint security agents, by their
+// per-open overhead), so it pays to have more reads in flight than CPUs, but
+// not so many that the filesystem just queues them.
+var scanWorkers = min(4*runtime.NumCPU(), 32)
+
+// scanFile is one regular file found by a scan, with just the metadata that
+// sizing, eviction and keep-warm need, so a whole cache fits in memory.
+type scanFile struct {
+	path    string
+	size    int64 // in the scan's measure; 0 for a hard link already counted
+	atime   time.Time
+	mtime   time.Time
+	removed bool // deleted by this run
+}
+
+// fileID identifies a file independently of the paths that lead to it.
+type fileID struct{ dev, ino uint64 }
+
+// cacheScan is the result of reading a cache directory tree once. A run scans
+// each cache a single time and shares the result between measuring it,
+// choosing what to evict, and keeping the survivors warm.
+//
+// A file's size is measured the way the cache's thresholds are: its apparent
+// length or the space it occupies on disk. The scan totals both, for
+// reporting. A file with several hard links in the tree counts once.
+type cacheScan struct {
+	root      string
+	files     []*scanFile // sorted by path
+	size      int64       // total in the scan's measure
+	apparent  int64       // total apparent size
+	allocated int64       // total bytes allocated on disk
+	err       error       // set if root itself could not be read
+}
+
+// scan returns this run's scan of the cache at path, scanning it on first
+// use, in the measure configured for that cache.
+func (c *Cleaner) scan(path string) *cacheScan {
+	if s, ok := c.scans[path]; ok {
+		return s
+	}
+	n, _ := c.named(path)
+	s := scanTree(path, n.Apparent())
+	c.scans[path] = s
+	return s
+}
+
+// describe reports both of the scan's totals for logging, and which one the
+// cache's thresholds apply to.
+func (s *cacheScan) describe(cc config.CacheConfig) string {
+	measure := config.MeasureAllocated
+	if cc.Apparent() {
+		measure = config.MeasureApparent
+	}
+	return fmt.Sprintf("%.1fGB allocated, %.1fGB apparent, measure: %s", toGB(s.allocated), toGB(s.apparent), measure)
+}
+
+// scannedFile is a scanFile along with the raw sizes and identity scanTree
+// needs to total them.
+type scannedFile struct {
+	*scanFile
+	apparent  int64
+	allocated int64
+	id        fileID
+	linked    bool // has other hard links; id is set
+}
+
+// scanTree reads every regular file under root using a bounded pool of
+// workers, each listing one directory at a time, and measures each file by
+// its apparent size if apparent is set, or else by its allocated size.
+// Unreadable entries below root are skipped, like a walk that ignores errors;
+// only a failure to read root itself is reported, in the result's err.
+func scanTree(root string, apparent bool) *cacheScan {
+	s := &cacheScan{root: root}
+	var found []scannedFile
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		queue   = []string{root}
+		pending = 1 // directories queued or being read
+		wg      sync.WaitGroup
+	)
+	for range scanWorkers {
+		wg.Go(func() {
+			for {
+				mu.Lock()
+				for len(queue) == 0 && pending > 0 {
+					cond.Wait()
+				}
+				if pending == 0 {
+					mu.Unlock()
+					return
+				}
+				dir := queue[len(queue)-1]
+				queue = queue[:len(queue)-1]
+				mu.Unlock()
+
+				files, subdirs, err := readScanDir(dir)
+
+				mu.Lock()
+				if err != nil && dir == root {
+					s.err = err
+				}
+				found = append(found, files...)
+				queue = append(queue, subdirs...)
+				pending += len(subdirs) - 1
+				mu.Unlock()
+				cond.Broadcast()
+			}
+		})
+	}
+	wg.Wait()
+
+	// Sort before counting hard links, so the same path counts on every run.
+	slices.SortFunc(found, func(a, b scannedFile) int { return strings.Compare(a.path, b.path) })
+	counted := make(map[fileID]bool)
+	s.files = make([]*scanFile, len(found))
+	for i, f := range found {
+		s.files[i] = f.scanFile
+		if f.linked {
+			if counted[f.id] {
+				continue
+			}
+			counted[f.id] = true
+		}
+		f.size = f.allocated
+		if apparent {
+			f.size = f.apparent
+		}
+		s.size += f.size
+		s.apparent += f.apparent
+		s.allocated += f.allocated
+	}
+	return s
+}
+
+// readScanDir lists one directory, returning its regular files and its
+// subdirectories. Symlinks are neither followed nor counted. Entries come back
+// in directory order; scanTree sorts the result once at the end.
+func readScanDir(dir string) (files []scannedFile, subdirs []string, err error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := d.ReadDir(-1)
+	_ = d.Close()
+	if err != nil && len(entries) == 0 {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			subdirs = append(subdirs, p)
+			continue
+		}
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		id, linked := hardLinkID(info)
+		files = append(files, scannedFile{
+			scanFile:  &scanFile{path: p, atime: fileATime(info), mtime: info.ModTime()},
+			apparent:  info.Size(),
+			allocated: fileAllocated(info),
+			id:        id,
+			linked:    linked,
+		})
+	}
+	return files, subdirs, nil
+}
+
+// live returns the files not removed by this run.
+func (s *cacheScan) live() []*scanFile {
+	return slices.DeleteFunc(slices.Clone(s.files), func(f *scanFile) bool { return f.removed })
+}
@@ -0,0 +1,177 @@
+package cleaner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+func TestScanTree(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{"README", "aa/aa01-a", "aa/aa02-d", "bb/cc/deep.go"} {
+		full := filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, make([]byte, 10000), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Symlinks are neither followed nor counted.
+	if err := os.Symlink(filepath.Join(root, "bb"), filepath.Join(root, "link")); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+
+	s := scanTree(root, false)
+	if s.err != nil {
+		t.Fatal(s.err)
+	}
+	var got []string
+	var sum int64
+	for _, f := range s.files {
+		rel, _ := filepath.Rel(root, f.path)
+		got = append(got, filepath.ToSlash(rel))
+		sum += f.size
+	}
+	want := []string{"README", "aa/aa01-a", "aa/aa02-d", "bb/cc/deep.go"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("files = %v, want %v (sorted by path)", got, want)
+	}
+	if s.size != sum || s.size == 0 {
+		t.Errorf("size = %d, want the sum of file sizes %d", s.size, sum)
+	}
+
+	s.files[0].removed = true
+	if live := s.live(); len(live) != 3 || live[0].path == s.files[0].path {
+		t.Errorf("live() should skip removed files, got %d", len(live))
+	}
+}
+
+func TestScanTreeMeasures(t *testing.T) {
+	if runtime.GOOS != "darwin" && runtime.GOOS != "linux" {
+		t.Skip("allocated sizes and hard links need Stat_t")
+	}
+	root := t.TempDir()
+	data := filepath.Join(root, "data")
+	if err := os.WriteFile(data, make([]byte, 10000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(data, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	sparse, err := os.Create(filepath.Join(root, "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sparse.Truncate(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	_ = sparse.Close()
+
+	s := scanTree(root, false)
+	if s.apparent != 10000+1<<20 {
+		t.Errorf("apparent = %d, want %d (hard link counted once)", s.apparent, 10000+1<<20)
+	}
+	if s.allocated < 10000 || s.allocated >= s.apparent {
+		t.Errorf("allocated = %d, want the linked file's blocks but not the sparse file's hole", s.allocated)
+	}
+	if s.size != s.allocated {
+		t.Errorf("size = %d, want allocated %d", s.size, s.allocated)
+	}
+	if s.files[1].size != 0 { // "link", sorted after "data"
+		t.Errorf("second hard link counted %d bytes, want 0", s.files[1].size)
+	}
+
+	if s := scanTree(root, true); s.size != s.apparent {
+		t.Errorf("apparent measure: size = %d, want %d", s.size, s.apparent)
+	}
+}
+
+func TestScanTreeMissingRoot(t *testing.T) {
+	s := scanTree(filepath.Join(t.TempDir(), "does-not-exist"), false)
+	if s.err == nil || len(s.files) != 0 || s.size != 0 {
+		t.Errorf("missing root: err=%v files=%d size=%d", s.err, len(s.files), s.size)
+	}
+}
+
+// TestScanIsShared verifies a run scans each cache once: eviction marks what it
+// removes in the shared scan, and keep-warm then skips those files.
+func TestScanIsShared(t *testing.T) {
+	root := t.TempDir()
+	writeBuildEntry(t, root, "aa01-d", 100, 0)
+	c := New(&config.Config{}, false, false)
+
+	s := c.scan(root)
+	if c.scan(root) != s {
+		t.Fatal("second scan of the same path should reuse the first")
+	}
+	if removed, _ := c.trimBuildCache(buildCacheEntries(s), s.size, 0); removed != 1 {
+		t.Fatalf("removed=%d, want 1", removed)
+	}
+	if _, scanned := c.keepWarm(root); scanned != 0 {
+		t.Errorf("keep-warm scanned %d files, want 0 after eviction", scanned)
+	}
+}
+
+// makeSyntheticCache lays out a build-cache-shaped tree of dirs × files small
+// files under root.
+func makeSyntheticCache(b *testing.B, root string, dirs, files int) {
+	b.Helper()
+	for d := range dirs {
+		dir := filepath.Join(root, fmt.Sprintf("%02x", d))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		for f := range files {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%02x%04d-d", d, f)), []byte("x"), 0644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// serialWalk is how cachegoat used to size a cache: a serial filepath.Walk
+// summing apparent sizes.
+func serialWalk(root string) int64 {
+	var size int64
+	_ = filepath.Walk(root, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// BenchmarkScanTree compares the concurrent scan against the serial walk it
+// replaced, on a synthetic 256 × 40 file build cache. A run used to walk each
+// cache three times — to size it, to list its entries, and to keep it warm —
+// so "walk-per-run" is the cost the single shared scan replaces. On a
+// multi-core machine a scan also beats a single walk, more so on filesystems
+// where every stat is slow, such as under endpoint security agents.
+func BenchmarkScanTree(b *testing.B) {
+	root := b.TempDir()
+	makeSyntheticCache(b, root, 256, 40)
+
+	b.Run("walk", func(b *testing.B) {
+		for b.Loop() {
+			serialWalk(root)
+		}
+	})
+	b.Run("walk-per-run", func(b *testing.B) {
+		for b.Loop() {
+			for range 3 {
+				serialWalk(root)
+			}
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for b.Loop() {
+			scanTree(root, false)
+		}
+	})
+}
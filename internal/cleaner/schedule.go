@@ -2,6 +2,7 @@ package cleaner
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -9,17 +10,19 @@ import (
 	"strings"
 )
 
-func Schedule() error {
-	bin := scheduleBinaryPath()
+// Schedule sets up cachegoat to run every 2 hours with the platform's
+// scheduler, reporting what it did to w.
+func Schedule(w io.Writer) error {
+	bin := scheduleBinaryPath(w)
 
 	switch runtime.GOOS {
 	case "darwin":
-		return scheduleLaunchd(bin)
+		return scheduleLaunchd(w, bin)
 	case "linux":
 		if hasSystemd() {
-			return scheduleSystemd(bin)
+			return scheduleSystemd(w, bin)
 		}
-		return scheduleCron(bin)
+		return scheduleCron(w, bin)
 	default:
 		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
 	}
@@ -30,20 +33,20 @@ func Schedule() error {
 // picked up without re-scheduling. If the running binary isn't on PATH (for
 // example a one-off build in a source tree), it warns, because upgrades won't
 // be reflected automatically.
-func scheduleBinaryPath() string {
+func scheduleBinaryPath(w io.Writer) string {
 	exe, _ := os.Executable()
 	exe = evalSymlinks(exe)
 
 	if p, err := exec.LookPath("cachegoat"); err == nil {
 		p = evalSymlinks(p)
 		if exe != "" && p != exe {
-			fmt.Printf("Note: scheduling the cachegoat on your PATH:\n  %s\n(not the binary you ran: %s)\n", p, exe)
+			fmt.Fprintf(w, "Note: scheduling the cachegoat on your PATH:\n  %s\n(not the binary you ran: %s)\n", p, exe)
 		}
 		return p
 	}
 
 	if exe != "" {
-		fmt.Printf("Warning: cachegoat is not on your PATH; scheduling:\n  %s\nUpgrades via 'go install' won't be picked up automatically — re-run --schedule after upgrading.\n", exe)
+		fmt.Fprintf(w, "Warning: cachegoat is not on your PATH; scheduling:\n  %s\nUpgrades via 'go install' won't be picked up automatically — re-run --schedule after upgrading.\n", exe)
 		return exe
 	}
 	return findBinary()
@@ -61,21 +64,23 @@ func evalSymlinks(p string) string {
 	return p
 }
 
-func Unschedule() error {
+// Unschedule removes the scheduled cleanup Schedule set up, reporting what it
+// did to w.
+func Unschedule(w io.Writer) error {
 	switch runtime.GOOS {
 	case "darwin":
-		return unscheduleLaunchd()
+		return unscheduleLaunchd(w)
 	case "linux":
 		if hasSystemd() {
-			return unscheduleSystemd()
+			return unscheduleSystemd(w)
 		}
-		return unscheduleCron()
+		return unscheduleCron(w)
 	default:
 		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
 	}
 }
 
-func scheduleLaunchd(bin string) error {
+func scheduleLaunchd(w io.Writer, bin string) error {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return fmt.Errorf("failed to get home dir: %w", err)
@@ -109,11 +114,11 @@ func scheduleLaunchd(bin string) error {
 		return fmt.Errorf("failed to load plist: %w", err)
 	}
 
-	fmt.Printf("✓ Scheduled cleanup every 2 hours\n  %s\n", plistPath)
+	fmt.Fprintf(w, "✓ Scheduled cleanup every 2 hours\n  %s\n", plistPath)
 	return nil
 }
 
-func unscheduleLaunchd() error {
+func unscheduleLaunchd(w io.Writer) error {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return fmt.Errorf("failed to get home dir: %w", err)
@@ -123,11 +128,11 @@ func unscheduleLaunchd() error {
 	_ = exec.Command("launchctl", "unload", plistPath).Run()
 	_ = os.Remove(plistPath)
 
-	fmt.Println("✓ Removed scheduled cleanup")
+	fmt.Fprintln(w, "✓ Removed scheduled cleanup")
 	return nil
 }
 
-func scheduleSystemd(bin string) error {
+func scheduleSystemd(w io.Writer, bin string) error {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return fmt.Errorf("failed to get home dir: %w", err)
@@ -167,11 +172,11 @@ WantedBy=timers.target
 		return fmt.Errorf("failed to enable timer: %w", err)
 	}
 
-	fmt.Println("✓ Scheduled cleanup every 2 hours (systemd timer)")
+	fmt.Fprintln(w, "✓ Scheduled cleanup every 2 hours (systemd timer)")
 	return nil
 }
 
-func unscheduleSystemd() error {
+func unscheduleSystemd(w io.Writer) error {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return fmt.Errorf("failed to get home dir: %w", err)
@@ -183,11 +188,11 @@ func unscheduleSystemd() error {
 	_ = os.Remove(filepath.Join(dir, "cachegoat.timer"))
 	_ = exec.Command("systemctl", "--user", "daemon-reload").Run()
 
-	fmt.Println("✓ Removed scheduled cleanup")
+	fmt.Fprintln(w, "✓ Removed scheduled cleanup")
 	return nil
 }
 
-func scheduleCron(bin string) error {
+func scheduleCron(w io.Writer, bin string) error {
 	out, _ := exec.Command("crontab", "-l").Output()
 	entry := fmt.Sprintf("0 */2 * * * %s\n", bin)
 
@@ -202,11 +207,11 @@ func scheduleCron(bin string) error {
 		return fmt.Errorf("failed to update crontab: %w", err)
 	}
 
-	fmt.Println("✓ Scheduled cleanup every 2 hours (cron)")
+	fmt.Fprintln(w, "✓ Scheduled cleanup every 2 hours (cron)")
 	return nil
 }
 
-func unscheduleCron() error {
+func unscheduleCron(w io.Writer) error {
 	out, _ := exec.Command("crontab", "-l").Output()
 	lines := strings.Split(string(out), "\n")
 	var newLines []string
@@ -220,7 +225,7 @@ func unscheduleCron() error {
 	cmd.Stdin = strings.NewReader(strings.Join(newLines, "\n"))
 	_ = cmd.Run()
 
-	fmt.Println("✓ Removed scheduled cleanup")
+	fmt.Fprintln(w, "✓ Removed scheduled cleanup")
 	return nil
 }
 
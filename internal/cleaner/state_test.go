@@ -0,0 +1,41 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestWriteJSONAtomicConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "state", "ledger.json")
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v := map[string]int{}
+			for j := range 100 * (i + 1) { // each writer's file a different length
+				v[string(rune('a'+j%26))+string(rune('a'+j/26))] = i
+			}
+			if err := writeJSONAtomic(file, v); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]int
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Errorf("saves mixed up: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(file)); len(entries) != 1 {
+		t.Errorf("%d files left in the state directory, want just the one saved", len(entries))
+	}
+}
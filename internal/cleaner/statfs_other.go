@@ -0,0 +1,24 @@
+//go:build !darwin && !linux
+
+package cleaner
+
+import "io/fs"
+
+// diskSpace reports filesystem capacity. Platforms without a real
+// implementation land here and report it as unknown, which leaves
+// percentage-based thresholds disabled rather than guessing.
+func diskSpace(_ string) (total, avail int64, ok bool) {
+	return 0, 0, false
+}
+
+// deviceOf reports the filesystem holding path as unknown, so only a cache
+// inside the relocation directory counts as relocated.
+func deviceOf(_ string) (uint64, bool) {
+	return 0, false
+}
+
+// ownedByCurrentUser reports every file as the current user's, having no
+// ownership to check.
+func ownedByCurrentUser(_ fs.FileInfo) bool {
+	return true
+}
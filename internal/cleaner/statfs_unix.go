@@ -0,0 +1,49 @@
+//go:build darwin || linux
+
+package cleaner
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// diskSpace reports the total size of the filesystem holding path and the
+// space on it available to unprivileged users, in bytes. A path that doesn't
+// exist yet is measured at its nearest existing ancestor.
+func diskSpace(path string) (total, avail int64, ok bool) {
+	path, ok = existingAncestor(path)
+	if !ok {
+		return 0, 0, false
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, false
+	}
+	return int64(st.Blocks) * int64(st.Bsize), int64(st.Bavail) * int64(st.Bsize), true
+}
+
+// deviceOf returns the ID of the filesystem holding path, or, if path doesn't
+// exist yet, its nearest existing ancestor.
+func deviceOf(path string) (uint64, bool) {
+	path, ok := existingAncestor(path)
+	if !ok {
+		return 0, false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+// ownedByCurrentUser reports whether the current user owns the file.
+func ownedByCurrentUser(info fs.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return !ok || int(st.Uid) == os.Getuid()
+}
@@ -0,0 +1,154 @@
+package cleaner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// warmStampRetention is how long keep-warm's access-time stamps are
+// remembered. A file still carrying a forgotten stamp just looks really used
+// at that time, which only ever delays its expiry.
+const warmStampRetention = 365 * 24 * time.Hour
+
+// usageLedger tracks when each module version in a module cache was last
+// really used, which access times alone can't tell once keep-warm is on.
+//
+// Go never marks module cache files as used, so a read's access time is the
+// only signal, and keep-warm overwrites exactly those access times on idle
+// files. To keep the two apart, keep-warm stamps access times with whole
+// seconds it records here, so any access time matching a stamp is known to be
+// synthetic. Each run folds the real access times it sees into LastUse, so a
+// version's last real use survives being warmed.
+//
+// A nil *usageLedger is valid and treats every access time as real.
+type usageLedger struct {
+	Path       string           `json:"path"`
+	WarmStamps []int64          `json:"warm_stamps,omitempty"`
+	LastUse    map[string]int64 `json:"last_use,omitempty"`
+
+	file  string // where the ledger is persisted; "" keeps it in memory
+	dirty bool
+}
+
+// usage returns the ledger for the cache at path, loading it from the state
+// directory on first use.
+func (c *Cleaner) usage(path string) *usageLedger {
+	if u, ok := c.ledgers[path]; ok {
+		return u
+	}
+	u := &usageLedger{Path: path}
+	if c.cfg.StateDir != "" {
+		sum := sha256.Sum256([]byte(path))
+		u.file = filepath.Join(c.cfg.StateDir, "usage", hex.EncodeToString(sum[:8])+".json")
+		if data, err := os.ReadFile(u.file); err == nil {
+			_ = json.Unmarshal(data, u)
+		}
+	}
+	if u.LastUse == nil {
+		u.LastUse = make(map[string]int64)
+	}
+	c.ledgers[path] = u
+	return u
+}
+
+// saveUsage persists the ledger of the cache at path, if it changed during
+// this run.
+func (c *Cleaner) saveUsage(path string) {
+	u, ok := c.ledgers[path]
+	if !ok {
+		return
+	}
+	if err := u.save(); err != nil {
+		c.failf(path, "usage: failed to save %s: %v", u.file, err)
+	}
+}
+
+func (u *usageLedger) save() error {
+	if u.file == "" || !u.dirty {
+		return nil
+	}
+	if err := writeJSONAtomic(u.file, u); err != nil {
+		return err
+	}
+	u.dirty = false
+	return nil
+}
+
+// stamp records t, truncated to the second, as an access time keep-warm is
+// about to set, and returns it.
+func (u *usageLedger) stamp(t time.Time) time.Time {
+	t = t.Truncate(time.Second)
+	if u == nil {
+		return t
+	}
+	if !slices.Contains(u.WarmStamps, t.Unix()) {
+		cutoff := t.Add(-warmStampRetention).Unix()
+		u.WarmStamps = slices.DeleteFunc(u.WarmStamps, func(s int64) bool { return s < cutoff })
+		u.WarmStamps = append(u.WarmStamps, t.Unix())
+		u.dirty = true
+	}
+	return t
+}
+
+// synthetic reports whether an access time was set by keep-warm rather than
+// left by a real read.
+func (u *usageLedger) synthetic(at time.Time) bool {
+	return u != nil && at.Nanosecond() == 0 && slices.Contains(u.WarmStamps, at.Unix())
+}
+
+// realUse returns when a file with the given access and modification times was
+// last really used: its access time, unless keep-warm set that, and never
+// earlier than its modification time.
+func (u *usageLedger) realUse(atime, mtime time.Time) time.Time {
+	if u.synthetic(atime) || atime.Before(mtime) {
+		return mtime
+	}
+	return atime
+}
+
+// observe records that the module version key was really used at t and
+// returns the latest real use known for it, which may predate this run.
+func (u *usageLedger) observe(key string, t time.Time) time.Time {
+	if u == nil {
+		return t
+	}
+	if prev, ok := u.LastUse[key]; ok && prev >= t.Unix() {
+		return time.Unix(prev, 0)
+	}
+	if !t.IsZero() {
+		u.LastUse[key] = t.Unix()
+		u.dirty = true
+	}
+	return t
+}
+
+// retain forgets every module version not in keep, such as those evicted by
+// cachegoat or removed by `go clean`.
+func (u *usageLedger) retain(keep map[string]bool) {
+	if u == nil {
+		return
+	}
+	for _, k := range slices.Collect(maps.Keys(u.LastUse)) {
+		if !keep[k] {
+			delete(u.LastUse, k)
+			u.dirty = true
+		}
+	}
+}
+
+// modVersionKey returns the module version ("path@version") a file under the
+// module cache at root belongs to, if any.
+func modVersionKey(root, p string) (string, bool) {
+	escPath, escVer, _, ok := splitModCachePath(root, p)
+	if !ok {
+		return "", false
+	}
+	v := &modVersion{escPath: escPath, escVer: escVer}
+	return v.String(), true
+}
@@ -0,0 +1,76 @@
+//go:build darwin || linux
+
+// These tests rely on access times, which are only observable on platforms
+// with a real fileATime implementation.
+
+package cleaner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/YakDriver/cachegoat/internal/config"
+)
+
+// TestExpireModVersionsSurvivesKeepWarm proves keep-warm refreshing access
+// times does not make an unused module version look recently used: its last
+// real use is remembered across runs via the usage ledger.
+func TestExpireModVersionsSurvivesKeepWarm(t *testing.T) {
+	root := t.TempDir()
+	writeModVersion(t, root, "example.com/stale", "v1.0.0", 10, 40*24*time.Hour)
+	writeModVersion(t, root, "example.com/fresh", "v1.0.0", 10, 10*24*time.Hour)
+	cfg := &config.Config{ModCache: config.CacheConfig{Path: root}, StateDir: t.TempDir()}
+
+	// First run: keep-warm refreshes every idle file's access time.
+	c := New(cfg, false, false)
+	if touched, _ := c.keepWarm(root); touched == 0 {
+		t.Fatal("expected keep-warm to touch idle files")
+	}
+	c.saveUsage(root)
+
+	// Second run, a new process: access times now all look fresh.
+	c = New(cfg, false, false)
+	versions := modCacheVersions(scanTree(root, false), c.usage(root))
+	survivors, _ := c.expireModVersions(root, versions, nil, 30*24*time.Hour)
+
+	if len(survivors) != 1 || survivors[0].String() != "example.com/fresh@v1.0.0" {
+		t.Fatalf("survivors = %v, want only example.com/fresh@v1.0.0", survivors)
+	}
+	if exists(filepath.Join(root, "example.com", "stale@v1.0.0")) {
+		t.Error("stale version should have expired despite being kept warm")
+	}
+}
+
+func TestRealUseAfterWarm(t *testing.T) {
+	tmp := t.TempDir()
+	f := filepath.Join(tmp, "f")
+	old := time.Now().Add(-10 * 24 * time.Hour)
+	writeFileAged(t, f, 0644, old, old)
+
+	u := &usageLedger{LastUse: map[string]int64{}}
+	stamp := u.stamp(time.Now())
+	if err := os.Chtimes(f, stamp, old); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.realUse(fileATime(info), info.ModTime()); !got.Equal(info.ModTime()) {
+		t.Errorf("stamped access time should not count as use: got %v", got)
+	}
+
+	// A later real read leaves a non-stamp access time.
+	read := stamp.Add(90 * time.Minute).Add(123 * time.Millisecond)
+	if err := os.Chtimes(f, read, old); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(f); err != nil {
+		t.Fatal(err)
+	}
+	if got := u.realUse(fileATime(info), info.ModTime()); !got.Equal(read) {
+		t.Errorf("real read should count as use: got %v, want %v", got, read)
+	}
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of cache. Go's own build and module caches are configured under
+// build_cache and mod_cache; every other cache, under caches, is one of the
+// generic kinds.
+const (
+	KindBuild = "build" // a Go build cache (GOCACHE)
+	KindMod   = "mod"   // a Go module cache (GOMODCACHE)
+
+	// KindFiles is a cache of independent files that its tool marks as used
+	// by bumping their modification time, as the build cache does, such as
+	// gopls's, golangci-lint's or staticcheck's. Files are evicted least
+	// recently used first.
+	KindFiles = "files"
+
+	// KindDirs is a cache whose entries are directories, matched by the
+	// Entries glob, such as one per module a tool downloads. Each entry is
+	// evicted whole, least recently used first.
+	KindDirs = "dirs"
+)
+
+// NamedCache is a cache beyond Go's build and module caches. Its path may
+// refer to ${GOCACHE}, ${GOMODCACHE}, ${GOPATH}, ${UserCacheDir} (the
+// platform's per-user cache directory, as os.UserCacheDir reports it) or any
+// environment variable, and Env, when set in the environment, overrides it.
+// A cache whose path doesn't exist is left alone.
+type NamedCache struct {
+	Name    string `yaml:"name"`
+	Kind    string `yaml:"kind,omitempty"`
+	Env     string `yaml:"env,omitempty"`
+	Entries string `yaml:"entries,omitempty"` // for KindDirs; default "*"
+
+	// KeepWarm, when set, overrides keep_warm for this cache.
+	KeepWarm *bool `yaml:"keep_warm,omitempty"`
+
+	// Disabled leaves the cache alone, such as a built-in one you'd rather
+	// manage yourself.
+	Disabled bool `yaml:"disabled,omitempty"`
+
+	// The size and age policy. With no max_size, the cache has no size
+	// limit, but min_free and max_age still apply.
+	CacheConfig `yaml:",inline"`
+}
+
+// WarmEnabled reports whether the cache is kept warm, given the global
+// keep_warm setting.
+func (n NamedCache) WarmEnabled(global bool) bool {
+	if n.KeepWarm != nil {
+		return *n.KeepWarm
+	}
+	return global
+}
+
+// builtinCaches are the caches of tools commonly used alongside Go, managed
+// unless disabled. An entry under caches with the same name overrides their
+// settings one by one.
+//
+// What Go keeps inside the module cache, such as VCS clones and downloaded
+// toolchains, is the module cache's to manage: it evicts toolchains, which
+// are module versions, as safely as any other, and each VCS clone whole.
+// GOPATH/pkg/sumdb isn't a cache at all: it's the checksum database's latest
+// signed tree head, which Go checks each new one against to detect a forked
+// or rolled back database.
+var builtinCaches = []NamedCache{
+	{Name: "gopls", Kind: KindFiles, CacheConfig: CacheConfig{
+		Path: "${UserCacheDir}/gopls", MaxSize: Size{Bytes: 2 << 30}, MaxAge: Duration(30 * day)}},
+	{Name: "golangci-lint", Kind: KindFiles, Env: "GOLANGCI_LINT_CACHE", CacheConfig: CacheConfig{
+		Path: "${UserCacheDir}/golangci-lint", MaxSize: Size{Bytes: 2 << 30}, MaxAge: Duration(30 * day)}},
+	{Name: "staticcheck", Kind: KindFiles, Env: "STATICCHECK_CACHE", CacheConfig: CacheConfig{
+		Path: "${UserCacheDir}/staticcheck", MaxSize: Size{Bytes: 2 << 30}, MaxAge: Duration(30 * day)}},
+}
+
+// BuiltinCacheNames returns the names of the built-in caches.
+func BuiltinCacheNames() []string {
+	names := make([]string, len(builtinCaches))
+	for i, b := range builtinCaches {
+		names[i] = b.Name
+	}
+	return names
+}
+
+// mergeCaches returns the caches to manage: each configured one, over the
+// built-in of the same name if there is one, in the order configured so keys
+// still match the config file, followed by the remaining built-ins.
+func mergeCaches(configured []NamedCache) []NamedCache {
+	var caches []NamedCache
+	used := make(map[string]bool)
+	for _, n := range configured {
+		for _, b := range builtinCaches {
+			if b.Name == n.Name {
+				n = b.overlay(n)
+				used[b.Name] = true
+			}
+		}
+		caches = append(caches, n)
+	}
+	for _, b := range builtinCaches {
+		if !used[b.Name] {
+			caches = append(caches, b)
+		}
+	}
+	return caches
+}
+
+// overlay returns n with every setting o makes replacing n's.
+func (n NamedCache) overlay(o NamedCache) NamedCache {
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&n.Kind, o.Kind)
+	set(&n.Env, o.Env)
+	set(&n.Entries, o.Entries)
+	n.CacheConfig = n.CacheConfig.overlay(o.CacheConfig)
+	if o.Path != "" {
+		n.Path = o.Path
+	}
+	if o.KeepWarm != nil {
+		n.KeepWarm = o.KeepWarm
+	}
+	n.Disabled = n.Disabled || o.Disabled
+	return n
+}
+
+// overlay returns c with every size, age and cleanup setting o makes
+// replacing c's. The path is c's.
+func (c CacheConfig) overlay(o CacheConfig) CacheConfig {
+	if o.Strategy != "" {
+		c.Strategy = o.Strategy
+	}
+	if o.Measure != "" {
+		c.Measure = o.Measure
+	}
+	// The whole-gigabyte sizes only apply when the others are unset, so
+	// setting one clears the other it would hide behind.
+	if o.MaxSizeGB != 0 {
+		c.MaxSize, c.MaxSizeGB = Size{}, o.MaxSizeGB
+	}
+	if o.TargetSizeGB != 0 {
+		c.TargetSize, c.TargetSizeGB = Size{}, o.TargetSizeGB
+	}
+	for _, s := range []struct{ dst, v *Size }{{&c.MaxSize, &o.MaxSize}, {&c.TargetSize, &o.TargetSize}, {&c.MinFree, &o.MinFree}} {
+		if !s.v.IsZero() {
+			*s.dst = *s.v
+		}
+	}
+	if o.MaxAge != 0 {
+		c.MaxAge = o.MaxAge
+	}
+	if o.KeepVersions != 0 {
+		c.KeepVersions = o.KeepVersions
+	}
+	if o.KeepReferenced != nil {
+		c.KeepReferenced = o.KeepReferenced
+	}
+	return c
+}
+
+// resolvePath works out where the cache is: the environment variable Env
+// names, if set, or else Path with ~ and its variables expanded. vars supplies
+// the variables cachegoat defines itself. A path using a variable that isn't
+// set resolves to "", so the cache is skipped.
+func (n *NamedCache) resolvePath(home string, vars func(string) string) {
+	if v := os.Getenv(n.Env); n.Env != "" && v != "" {
+		n.Path = v
+		return
+	}
+	unresolved := false
+	p := os.Expand(expandHome(n.Path, home), func(name string) string {
+		v := vars(name)
+		if v == "" {
+			v = os.Getenv(name)
+		}
+		unresolved = unresolved || v == ""
+		return v
+	})
+	switch {
+	case unresolved:
+		n.Path = "" // rather than a path with a piece missing
+	case p != "":
+		n.Path = filepath.Clean(p)
+	}
+}
+
+// cacheVars returns the variables a cache path may use, looked up only when
+// used.
+func (c *Config) cacheVars() func(string) string {
+	var gopath *string
+	return func(name string) string {
+		switch name {
+		case "GOCACHE":
+			return c.BuildCache.Path
+		case "GOMODCACHE":
+			return c.ModCache.Path
+		case "GOPATH":
+			if gopath == nil {
+				// GOPATH may be a list; Go keeps its own files in the first.
+				p, _, _ := strings.Cut(goEnv("GOPATH"), string(filepath.ListSeparator))
+				gopath = &p
+			}
+			return *gopath
+		case "UserCacheDir":
+			dir, _ := os.UserCacheDir()
+			return dir
+		}
+		return ""
+	}
+}
+
+// AllCaches returns every cache to manage: the build cache, the module cache,
+// and each named cache that's enabled and has a path.
+func (c *Config) AllCaches() []NamedCache {
+	caches := []NamedCache{
+		{Name: KindBuild, Kind: KindBuild, CacheConfig: c.BuildCache},
+		{Name: KindMod, Kind: KindMod, CacheConfig: c.ModCache},
+	}
+	for _, n := range c.Caches {
+		if !n.Disabled && n.Path != "" {
+			caches = append(caches, n)
+		}
+	}
+	return caches
+}
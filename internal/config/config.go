@@ -2,17 +2,136 @@ package config
 
 import (
 	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
 	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
+// Cleanup strategies for a cache that has crossed its size threshold.
+const (
+	// StrategyTrim evicts the least-recently-used entries until the cache is
+	// back under its target size.
+	StrategyTrim = "trim"
+	// StrategyPurge wipes the whole cache with `go clean`.
+	StrategyPurge = "purge"
+)
+
+// Measures of a cache's size that its thresholds can apply to.
+const (
+	// MeasureAllocated counts the disk space files occupy, as du reports it.
+	MeasureAllocated = "allocated"
+	// MeasureApparent counts the files' lengths, as ls reports them.
+	MeasureApparent = "apparent"
+)
+
+// DefaultProtectCommands are the commands ProtectBuilds waits for unless
+// protect_commands says otherwise: the go commands that read or fill the
+// caches, including the go list gopls runs to index a workspace, the linters
+// that keep caches of their own, and the builder Bazel's rules_go runs. gopls
+// itself isn't one: it runs as long as an editor is open, and would put off
+// every cleanup.
+var DefaultProtectCommands = []string{
+	"go build", "go test", "go install", "go run", "go vet", "go generate",
+	"go list", "go get", "go mod", "go work",
+	"golangci-lint", "staticcheck",
+	"builder compile*", "builder link", "builder stdlib",
+}
+
+// defaultTargetRatio is the fraction of the high-water mark a trim stops at
+// when no explicit target is configured. Trimming below the threshold, rather
+// than to just under it, keeps the next few builds from immediately tripping
+// it again.
+const defaultTargetRatio = 0.8
+
+const bytesPerGB = 1 << 30
+
+// DefaultRelocateTo is where Recommend moves caches when relocate_to is unset.
+const DefaultRelocateTo = "/tmp"
+
 type CacheConfig struct {
-	Path      string `yaml:"path"`
-	MaxSizeGB int    `yaml:"max_size_gb"`
+	Path string `yaml:"path"`
+
+	// MaxSize and TargetSize are the high- and low-water marks: a cache that
+	// reaches MaxSize is trimmed down to TargetSize. Either may be a
+	// percentage of the cache's filesystem. When unset, they fall back to the
+	// whole-gigabyte MaxSizeGB and TargetSizeGB, kept for older configs.
+	MaxSize      Size `yaml:"max_size,omitempty"`
+	TargetSize   Size `yaml:"target_size,omitempty"`
+	MaxSizeGB    int  `yaml:"max_size_gb,omitempty"`
+	TargetSizeGB int  `yaml:"target_size_gb,omitempty"`
+
+	// MinFree triggers a cleanup whenever free space on the cache's
+	// filesystem drops below it, shrinking the cache by at least the
+	// shortfall, even while the cache is under MaxSize.
+	MinFree Size `yaml:"min_free,omitempty"`
+
+	Strategy string `yaml:"strategy,omitempty"`
+
+	// Measure selects which size MaxSize, TargetSize and MinFree's shortfall
+	// are compared against: allocated (the default) or apparent.
+	Measure string `yaml:"measure,omitempty"`
+
+	// MaxAge, when set, removes entries (build cache) or module versions (mod
+	// cache) not used for this long on every run, whatever the cache's size.
+	MaxAge Duration `yaml:"max_age,omitempty"`
+
+	// KeepVersions, when positive, limits each module in the module cache to
+	// its newest KeepVersions versions on every run, regardless of size.
+	// Versions referenced by any go.mod or go.sum in KeepReferenced are kept
+	// on top of that. Both apply to the module cache only.
+	KeepVersions   int      `yaml:"keep_versions,omitempty"`
+	KeepReferenced []string `yaml:"keep_referenced,omitempty"`
+}
+
+// High returns the configured high-water mark.
+func (c CacheConfig) High() Size {
+	if !c.MaxSize.IsZero() {
+		return c.MaxSize
+	}
+	return Size{Bytes: int64(c.MaxSizeGB) * bytesPerGB}
+}
+
+// Limits resolves the high- and low-water marks in bytes for a cache on a
+// filesystem of fsSize bytes. The low-water mark is the configured target, or
+// 80% of the high-water mark when unset or not below it. A percentage that
+// can't be resolved because fsSize is unknown disables the limits: high comes
+// back as -1, which callers treat as "never clean".
+func (c CacheConfig) Limits(fsSize int64) (high, low int64) {
+	high = c.High().Resolve(fsSize)
+	if high < 0 {
+		return -1, -1
+	}
+	low = -1
+	if !c.TargetSize.IsZero() {
+		low = c.TargetSize.Resolve(fsSize)
+	} else if c.TargetSizeGB > 0 {
+		low = int64(c.TargetSizeGB) * bytesPerGB
+	}
+	if low < 0 || low >= high {
+		low = int64(float64(high) * defaultTargetRatio)
+	}
+	return high, low
+}
+
+// Purge reports whether the cache should be wiped outright rather than
+// trimmed once it crosses its threshold.
+func (c CacheConfig) Purge() bool {
+	return c.Strategy == StrategyPurge
+}
+
+// Apparent reports whether the cache's thresholds apply to its apparent size
+// rather than the space it occupies on disk.
+func (c CacheConfig) Apparent() bool {
+	return c.Measure == MeasureApparent
 }
 
 type Config struct {
@@ -21,52 +140,225 @@ type Config struct {
 	ProtectBuilds bool        `yaml:"protect_builds"`
 	KeepWarm      bool        `yaml:"keep_warm"`
 	LogPath       string      `yaml:"log_path"`
+
+	// ProtectCommands are the commands whose running processes count as a
+	// build for ProtectBuilds. Each is an executable name, or a path, followed
+	// by the arguments it must be running with, ignoring flags, any of which
+	// may be a glob: "go test" matches /usr/local/go/bin/go test -v ./....
+	ProtectCommands []string `yaml:"protect_commands,omitempty"`
+
+	// ProtectBuildsWait is how long a run waits for running builds to finish
+	// before skipping cleanup. MaxDeferrals, when positive, is how many runs
+	// in a row may skip cleanup for a build before the next cleans anyway.
+	ProtectBuildsWait Duration `yaml:"protect_builds_wait,omitempty"`
+	MaxDeferrals      int      `yaml:"max_deferrals,omitempty"`
+
+	// StateDir holds what cachegoat remembers between runs, such as when each
+	// module version was last really used. Load defaults it to
+	// $XDG_STATE_HOME/cachegoat or ~/.local/state/cachegoat; a Config with no
+	// StateDir persists nothing.
+	StateDir string `yaml:"state_dir"`
+
+	// RelocateTo is the directory Recommend moves the caches into to get
+	// them out of an antivirus scanner's way, such as a tmpfs or a scratch
+	// mount the scanner excludes. Each user gets their own subdirectory.
+	// Keep-warm assumes a temp cleaner may sweep it.
+	RelocateTo string `yaml:"relocate_to"`
+
+	// ProtectWorkspaces lists project directories whose go.mod, go.sum and
+	// go.work files pin module versions against selective mod cache eviction.
+	ProtectWorkspaces []string `yaml:"protect_workspaces,omitempty"`
+
+	// DiscoverCaches adds a profile for each build or module cache the go
+	// command would use in one of ProtectWorkspaces, going by its environment
+	// files, that no profile manages already.
+	DiscoverCaches bool `yaml:"discover_caches"`
+
+	// Caches are the caches managed besides the build and module caches: the
+	// built-in ones (see BuiltinCacheNames), with any settings made here, and
+	// any listed here. Load resolves each one's path.
+	Caches []NamedCache `yaml:"caches,omitempty"`
+
+	// Profiles are further pairs of build and module caches to manage, each
+	// under its own name. The top-level ones are DefaultProfile.
+	Profiles []Profile `yaml:"profiles,omitempty"`
+
+	files     []string            // the config files read, lowest precedence first
+	positions map[string]position // where each key was last set, by dotted key
+	derived   map[string]Source   // sources other than a config file, by dotted key
 }
 
+// Load resolves the configuration from the config files, the go command and
+// the environment. The user's config file (see File) is merged over the
+// system-wide one, key by key, so an administrator can set defaults that each
+// user overrides. A config file that doesn't parse, has unknown keys, or sets
+// invalid values is an error, a *ValidationError listing every problem with
+// where it is, rather than a silent fall back to defaults.
 func Load() (*Config, error) {
 	cfg := defaults()
-
-	// Load from ~/.cachegoat.yml if exists
 	home, _ := os.UserHomeDir()
-	if data, err := os.ReadFile(filepath.Join(home, ".cachegoat.yml")); err == nil {
-		_ = yaml.Unmarshal(data, cfg)
-	}
 
-	// Fall back to go env if not set in config
-	if cfg.BuildCache.Path == "" {
-		cfg.BuildCache.Path = goEnv("GOCACHE")
+	positions := make(map[string]position)
+	user, explicit := userFile()
+	for _, file := range []string{systemFile, user} {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) && !(explicit && file == user) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pos, problems := decodeStrict(file, data, cfg)
+		if len(problems) > 0 {
+			return nil, &ValidationError{Problems: problems}
+		}
+		maps.Copy(positions, pos)
+		cfg.files = append(cfg.files, file)
 	}
-	if cfg.ModCache.Path == "" {
-		cfg.ModCache.Path = goEnv("GOMODCACHE")
+	cfg.positions = positions
+
+	if cfg.StateDir == "" {
+		cfg.StateDir = defaultStateDir(home)
 	}
 
-	// Fall back to standard env vars if go env didn't work
-	if cfg.BuildCache.Path == "" {
-		cfg.BuildCache.Path = os.Getenv("GOCACHE")
+	// Expand ~ in configured paths
+	cfg.StateDir = expandHome(cfg.StateDir, home)
+	cfg.RelocateTo = expandHome(cfg.RelocateTo, home)
+	cfg.LogPath = expandHome(cfg.LogPath, home)
+	cfg.BuildCache.Path = expandHome(cfg.BuildCache.Path, home)
+	cfg.ModCache.Path = expandHome(cfg.ModCache.Path, home)
+	for i, p := range cfg.ProtectWorkspaces {
+		cfg.ProtectWorkspaces[i] = expandHome(p, home)
+	}
+	for i, p := range cfg.ModCache.KeepReferenced {
+		cfg.ModCache.KeepReferenced[i] = expandHome(p, home)
 	}
-	if cfg.ModCache.Path == "" {
-		cfg.ModCache.Path = os.Getenv("GOMODCACHE")
+	for i := range cfg.Profiles {
+		p := &cfg.Profiles[i]
+		for _, cc := range []*CacheConfig{&p.BuildCache, &p.ModCache} {
+			cc.Path = expandHome(cc.Path, home)
+			for j, r := range cc.KeepReferenced {
+				cc.KeepReferenced[j] = expandHome(r, home)
+			}
+		}
 	}
 
-	// Environment overrides (highest priority)
-	if v := os.Getenv("CACHEGOAT_BUILD_PATH"); v != "" {
-		cfg.BuildCache.Path = v
+	// A cache path not set in a config file falls back to go env, then to the
+	// go command's own environment variable, in case go env didn't work. The
+	// CACHEGOAT_* variables override everything.
+	cfg.derived = make(map[string]Source)
+	for _, p := range []struct {
+		key, goVar, envVar string
+		path               *string
+	}{
+		{"build_cache.path", "GOCACHE", "CACHEGOAT_BUILD_PATH", &cfg.BuildCache.Path},
+		{"mod_cache.path", "GOMODCACHE", "CACHEGOAT_MOD_PATH", &cfg.ModCache.Path},
+	} {
+		if *p.path == "" {
+			if *p.path = goEnv(p.goVar); *p.path != "" {
+				cfg.derived[p.key] = Source{Kind: SourceGoEnv, Var: p.goVar}
+			}
+		}
+		if *p.path == "" {
+			if *p.path = os.Getenv(p.goVar); *p.path != "" {
+				cfg.derived[p.key] = Source{Kind: SourceEnv, Var: p.goVar}
+			}
+		}
+		if v := os.Getenv(p.envVar); v != "" {
+			*p.path = v
+			cfg.derived[p.key] = Source{Kind: SourceEnv, Var: p.envVar}
+			delete(positions, p.key) // so problems aren't pinned on the file
+		}
 	}
-	if v := os.Getenv("CACHEGOAT_MOD_PATH"); v != "" {
-		cfg.ModCache.Path = v
+
+	cfg.Caches = mergeCaches(cfg.Caches)
+	vars := cfg.cacheVars()
+	for i := range cfg.Caches {
+		n := &cfg.Caches[i]
+		n.resolvePath(home, vars)
+		if v := os.Getenv(n.Env); n.Env != "" && v != "" {
+			key := fmt.Sprintf("caches[%d].path", i)
+			cfg.derived[key] = Source{Kind: SourceEnv, Var: n.Env}
+			delete(positions, key)
+		}
 	}
 
+	if problems := cfg.validate(home); len(problems) > 0 {
+		locate(problems, positions)
+		slices.SortStableFunc(problems, func(a, b Problem) int {
+			return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+		})
+		return nil, &ValidationError{Problems: problems}
+	}
+	cfg.discoverCaches(home)
 	return cfg, nil
 }
 
 func defaults() *Config {
 	return &Config{
-		BuildCache:    CacheConfig{MaxSizeGB: 30},
-		ModCache:      CacheConfig{MaxSizeGB: 10},
-		ProtectBuilds: true,
-		KeepWarm:      true,
-		LogPath:       "/tmp/cachegoat.log",
+		BuildCache:      CacheConfig{MaxSizeGB: 30},
+		ModCache:        CacheConfig{MaxSizeGB: 10},
+		ProtectBuilds:   true,
+		ProtectCommands: slices.Clone(DefaultProtectCommands),
+		KeepWarm:        true,
+		DiscoverCaches:  true,
+		LogPath:         "/tmp/cachegoat.log",
+		RelocateTo:      DefaultRelocateTo,
+	}
+}
+
+// RelocateRoot returns the configured relocation directory, or
+// DefaultRelocateTo when unset.
+func (c *Config) RelocateRoot() string {
+	if c.RelocateTo == "" {
+		return DefaultRelocateTo
+	}
+	return c.RelocateTo
+}
+
+// RelocateDir returns the current user's subdirectory of the relocation
+// directory, so users sharing a machine don't share, or fight over, caches.
+func (c *Config) RelocateDir() string {
+	return filepath.Join(c.RelocateRoot(), userName())
+}
+
+// RelocatedPaths returns where Recommend moves the build and module caches.
+func (c *Config) RelocatedPaths() (build, mod string) {
+	dir := c.RelocateDir()
+	return filepath.Join(dir, "go-cache"), filepath.Join(dir, "go-mod-cache")
+}
+
+// userName returns the current user's login name, or "" if it's unknown.
+func userName() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		// Windows names come as DOMAIN\user.
+		return u.Username[strings.LastIndex(u.Username, `\`)+1:]
+	}
+	return os.Getenv("USER")
+}
+
+// defaultStateDir follows the XDG base directory spec:
+// $XDG_STATE_HOME/cachegoat, or ~/.local/state/cachegoat.
+func defaultStateDir(home string) string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "cachegoat")
+	}
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "cachegoat")
+}
+
+// expandHome replaces a leading "~" in a configured path with the home
+// directory, since YAML values don't get shell expansion.
+func expandHome(p, home string) string {
+	if p == "~" && home != "" {
+		return home
 	}
+	if rest, ok := strings.CutPrefix(p, "~/"); ok && home != "" {
+		return filepath.Join(home, rest)
+	}
+	return p
 }
 
 func goEnv(key string) string {
@@ -77,6 +369,16 @@ func goEnv(key string) string {
 	return strings.TrimSpace(string(out))
 }
 
+// JSON renders the configuration as JSON, with the same keys and value
+// formats as the YAML config file.
+func (c *Config) JSON() ([]byte, error) {
+	var doc any
+	if err := yaml.Unmarshal([]byte(c.String()), &doc); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
 func (c *Config) String() string {
 	var buf bytes.Buffer
 	enc := yaml.NewEncoder(&buf)
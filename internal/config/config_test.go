@@ -1,9 +1,13 @@
 package config
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDefaults(t *testing.T) {
@@ -23,6 +27,28 @@ func TestDefaults(t *testing.T) {
 	}
 }
 
+func TestConfigJSON(t *testing.T) {
+	cfg := defaults()
+	cfg.BuildCache.MaxSize = Size{Bytes: 25 << 30}
+
+	data, err := cfg.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		BuildCache struct {
+			MaxSize string `json:"max_size"`
+		} `json:"build_cache"`
+		KeepWarm bool `json:"keep_warm"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	if doc.BuildCache.MaxSize != "25GiB" || !doc.KeepWarm {
+		t.Errorf("got %s", data)
+	}
+}
+
 func TestLoadKeepWarmFalse(t *testing.T) {
 	tmp := t.TempDir()
 	t.Setenv("HOME", tmp)
@@ -95,6 +121,229 @@ func TestEnvOverrides(t *testing.T) {
 	}
 }
 
+func TestLimits(t *testing.T) {
+	const gib = 1 << 30
+	cases := []struct {
+		name      string
+		cc        CacheConfig
+		fs        int64
+		high, low int64
+	}{
+		{"legacy default target", CacheConfig{MaxSizeGB: 30}, 0, 30 * gib, 24 * gib},
+		{"legacy explicit target", CacheConfig{MaxSizeGB: 30, TargetSizeGB: 20}, 0, 30 * gib, 20 * gib},
+		{"target not below max", CacheConfig{MaxSizeGB: 30, TargetSizeGB: 30}, 0, 30 * gib, 24 * gib},
+		{"always clean", CacheConfig{MaxSizeGB: 0}, 0, 0, 0},
+		{"max_size overrides max_size_gb", CacheConfig{MaxSizeGB: 30, MaxSize: Size{Bytes: 512 << 20}}, 0, 512 << 20, 512 << 20 * 8 / 10},
+		{"target_size", CacheConfig{MaxSize: Size{Bytes: 10 * gib}, TargetSize: Size{Bytes: 4 * gib}}, 0, 10 * gib, 4 * gib},
+		{"percent of filesystem", CacheConfig{MaxSize: Size{Percent: 10}, TargetSize: Size{Percent: 5}}, 100 * gib, 10 * gib, 5 * gib},
+		{"percent with unknown filesystem", CacheConfig{MaxSize: Size{Percent: 10}}, 0, -1, -1},
+	}
+	for _, c := range cases {
+		high, low := c.cc.Limits(c.fs)
+		if high != c.high || low != c.low {
+			t.Errorf("%s: Limits() = %d, %d; want %d, %d", c.name, high, low, c.high, c.low)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]Size{
+		"512MB":   {Bytes: 512 << 20},
+		"25GiB":   {Bytes: 25 << 30},
+		"1.5g":    {Bytes: 3 << 29},
+		"2 TB":    {Bytes: 2 << 40},
+		"1048576": {Bytes: 1 << 20},
+		"10%":     {Percent: 10},
+		"2.5 %":   {Percent: 2.5},
+	}
+	for in, want := range cases {
+		got, err := ParseSize(in)
+		if err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %+v, %v; want %+v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "GB", "-1GB", "ten gigs", "0%", "150%", "inf", "+Inf", "-inf", "NaN", "nan GiB", "NaN%", "inf%", "1e30TB"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) should fail", in)
+		}
+	}
+}
+
+func TestSizeString(t *testing.T) {
+	cases := map[Size]string{
+		{Bytes: 25 << 30}:  "25GiB",
+		{Bytes: 3 << 29}:   "1.5GiB",
+		{Bytes: 512 << 20}: "512MiB",
+		{Bytes: 100}:       "100B",
+		{Percent: 10}:      "10%",
+	}
+	for in, want := range cases {
+		if got := in.String(); got != want {
+			t.Errorf("%+v.String() = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLoadSizes(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("HOME", tmp)
+
+	yaml := `
+build_cache:
+  max_size: 25GiB
+  target_size: 20GiB
+mod_cache:
+  max_size_gb: 12
+`
+	if err := os.WriteFile(filepath.Join(tmp, ".cachegoat.yml"), []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if high, low := cfg.BuildCache.Limits(0); high != 25<<30 || low != 20<<30 {
+		t.Errorf("build cache limits = %d, %d; want 25GiB, 20GiB", high, low)
+	}
+	if high, _ := cfg.ModCache.Limits(0); high != 12<<30 {
+		t.Errorf("mod cache high = %d, want legacy max_size_gb of 12GiB", high)
+	}
+}
+
+func TestLoadStrategy(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("HOME", tmp)
+
+	yaml := "build_cache:\n  strategy: purge\n"
+	if err := os.WriteFile(filepath.Join(tmp, ".cachegoat.yml"), []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.BuildCache.Purge() {
+		t.Error("expected build cache strategy purge")
+	}
+	if cfg.ModCache.Purge() {
+		t.Error("expected mod cache to keep the default strategy")
+	}
+}
+
+func TestLoadMeasure(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("HOME", tmp)
+
+	yaml := "mod_cache:\n  measure: apparent\n"
+	if err := os.WriteFile(filepath.Join(tmp, ".cachegoat.yml"), []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.ModCache.Apparent() {
+		t.Error("expected mod cache to measure apparent size")
+	}
+	if cfg.BuildCache.Apparent() {
+		t.Error("expected build cache to default to allocated size")
+	}
+}
+
+func TestLoadProtectWorkspacesExpandsHome(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("HOME", tmp)
+
+	yaml := "protect_workspaces:\n  - ~/src\n  - /abs/path\nmod_cache:\n  keep_referenced: [~/app/go.sum]\n"
+	if err := os.WriteFile(filepath.Join(tmp, ".cachegoat.yml"), []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(tmp, "src"), "/abs/path"}; len(cfg.ProtectWorkspaces) != 2 ||
+		cfg.ProtectWorkspaces[0] != want[0] || cfg.ProtectWorkspaces[1] != want[1] {
+		t.Errorf("protect_workspaces = %v, want %v", cfg.ProtectWorkspaces, want)
+	}
+	if want := filepath.Join(tmp, "app", "go.sum"); len(cfg.ModCache.KeepReferenced) != 1 || cfg.ModCache.KeepReferenced[0] != want {
+		t.Errorf("keep_referenced = %v, want [%s]", cfg.ModCache.KeepReferenced, want)
+	}
+}
+
+func TestLoadRelocateTo(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("HOME", tmp)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RelocateTo != DefaultRelocateTo {
+		t.Errorf("default relocate_to = %q, want %q", cfg.RelocateTo, DefaultRelocateTo)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmp, ".cachegoat.yml"), []byte("relocate_to: ~/scratch\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = Load(); err != nil {
+		t.Fatal(err)
+	}
+	dir := cfg.RelocateDir()
+	if filepath.Dir(dir) != filepath.Join(tmp, "scratch") || filepath.Base(dir) != userName() {
+		t.Errorf("RelocateDir() = %s, want a per-user subdirectory of %s", dir, filepath.Join(tmp, "scratch"))
+	}
+	build, mod := cfg.RelocatedPaths()
+	if build != filepath.Join(dir, "go-cache") || mod != filepath.Join(dir, "go-mod-cache") {
+		t.Errorf("RelocatedPaths() = %s, %s", build, mod)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"30d":  30 * 24 * time.Hour,
+		"2w":   14 * 24 * time.Hour,
+		"1.5d": 36 * time.Hour,
+		"36h":  36 * time.Hour,
+	}
+	for in, want := range cases {
+		got, err := ParseDuration(in)
+		if err != nil || got != want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "d", "thirty days", "30x", "infd", "NaNw", "1e30d"} {
+		if _, err := ParseDuration(in); err == nil {
+			t.Errorf("ParseDuration(%q) should fail", in)
+		}
+	}
+}
+
+func TestLoadMaxAge(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("HOME", tmp)
+
+	yaml := "mod_cache:\n  max_age: 30d\n"
+	if err := os.WriteFile(filepath.Join(tmp, ".cachegoat.yml"), []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := time.Duration(cfg.ModCache.MaxAge); got != 30*24*time.Hour {
+		t.Errorf("max_age = %v, want 720h", got)
+	}
+	if !contains(cfg.String(), "max_age: 30d") {
+		t.Errorf("expected max_age to round-trip as 30d, got:\n%s", cfg.String())
+	}
+}
+
 func TestConfigString(t *testing.T) {
 	cfg := defaults()
 	cfg.BuildCache.Path = "/test/path"
@@ -120,3 +369,364 @@ func containsAt(s, substr string) bool {
 	}
 	return false
 }
+
+func TestSetFileValue(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".cachegoat.yml")
+	orig := "# my settings\nbuild_cache:\n  max_size: 80GiB # too big\n  strategy: trim\nkeep_warm: false\n"
+	if err := os.WriteFile(file, []byte(orig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetFileValue(file, "build_cache.max_size", "30GiB"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetFileValue(file, "mod_cache.max_size", "5GiB"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(file)
+	got := string(data)
+	for _, want := range []string{"# my settings", "max_size: 30GiB", "strategy: trim", "keep_warm: false", "mod_cache:\n  max_size: 5GiB"} {
+		if !contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600 preserved", info.Mode().Perm())
+	}
+}
+
+func TestSetFileValueCreatesFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".cachegoat.yml")
+	if err := SetFileValue(file, "build_cache.max_size", "30GiB"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(file)
+	if string(data) != "build_cache:\n  max_size: 30GiB\n" {
+		t.Errorf("got %q", data)
+	}
+}
+
+func TestLoadMergesSystemFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("CACHEGOAT_CONFIG", "")
+	orig := systemFile
+	systemFile = filepath.Join(t.TempDir(), "cachegoat.yml")
+	t.Cleanup(func() { systemFile = orig })
+
+	system := "build_cache:\n  max_size: 50GiB\n  strategy: purge\nkeep_warm: false\n"
+	if err := os.WriteFile(systemFile, []byte(system), 0644); err != nil {
+		t.Fatal(err)
+	}
+	user := filepath.Join(home, fileName)
+	if err := os.WriteFile(user, []byte("build_cache:\n  max_size: 20GiB\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BuildCache.MaxSize.Bytes != 20<<30 || !cfg.BuildCache.Purge() || cfg.KeepWarm {
+		t.Errorf("max_size=%v strategy=%q keep_warm=%v, want the user's size over the system's other settings",
+			cfg.BuildCache.MaxSize, cfg.BuildCache.Strategy, cfg.KeepWarm)
+	}
+	for key, want := range map[string]string{
+		"build_cache.max_size": user,
+		"build_cache.strategy": systemFile,
+		"keep_warm":            systemFile,
+		"mod_cache.max_size":   "",
+	} {
+		if got := cfg.Source(key).File; got != want {
+			t.Errorf("Source(%q) = %q, want %q", key, got, want)
+		}
+	}
+	if got := cfg.Annotated(); !contains(got, "max_size: 20GiB # "+user+":2") || !contains(got, "strategy: purge # "+systemFile+":3") {
+		t.Errorf("Annotated() doesn't note the sources:\n%s", got)
+	}
+}
+
+func TestUserConfigFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("CACHEGOAT_CONFIG", "")
+
+	if got, want := File(), filepath.Join(home, fileName); got != want {
+		t.Errorf("with no config file, File() = %q, want %q", got, want)
+	}
+
+	xdg := filepath.Join(home, ".config", "cachegoat", "config.yml")
+	if err := os.MkdirAll(filepath.Dir(xdg), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(xdg, []byte("keep_warm: false\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := File(); got != xdg {
+		t.Errorf("File() = %q, want the XDG config file %q", got, xdg)
+	}
+
+	env := filepath.Join(t.TempDir(), "fleet.yml")
+	t.Setenv("CACHEGOAT_CONFIG", env)
+	if got := File(); got != env {
+		t.Errorf("File() = %q, want $CACHEGOAT_CONFIG %q", got, env)
+	}
+	if _, err := Load(); !os.IsNotExist(err) {
+		t.Errorf("a config file named explicitly must exist, got %v", err)
+	}
+
+	SetFile(xdg)
+	t.Cleanup(func() { SetFile("") })
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.KeepWarm || cfg.Source("keep_warm").File != xdg {
+		t.Errorf("--config-file should win over $CACHEGOAT_CONFIG: keep_warm=%v from %q", cfg.KeepWarm, cfg.Source("keep_warm"))
+	}
+}
+
+func TestConfigSources(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("CACHEGOAT_CONFIG", "")
+	t.Setenv("CACHEGOAT_BUILD_PATH", "")
+	goCache, goModCache := filepath.Join(home, "gocache"), filepath.Join(home, "gomodcache")
+	t.Setenv("GOCACHE", goCache)
+	t.Setenv("GOMODCACHE", goModCache)
+	file := filepath.Join(home, fileName)
+	if err := os.WriteFile(file, []byte("keep_warm: false\nbuild_cache:\n  path: /elsewhere/go-build\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"keep_warm":        file + ":1",
+		"build_cache.path": file + ":3",
+		"mod_cache.path":   "go env GOMODCACHE",
+		"log_path":         "default",
+	} {
+		if got := cfg.Source(key).String(); got != want {
+			t.Errorf("Source(%q) = %q, want %q", key, got, want)
+		}
+	}
+	if got := cfg.Annotated(); !contains(got, "path: "+goModCache+" # go env GOMODCACHE") || !contains(got, "log_path: /tmp/cachegoat.log # default") {
+		t.Errorf("Annotated() doesn't note the sources:\n%s", got)
+	}
+	if w := cfg.Warnings(); len(w) != 1 || !contains(w[0], "build_cache.path is /elsewhere/go-build") || !contains(w[0], "go env GOCACHE is "+goCache) {
+		t.Errorf("want one warning that the build cache isn't Go's, got %q", w)
+	}
+
+	t.Setenv("CACHEGOAT_BUILD_PATH", goCache)
+	if cfg, err = Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Source("build_cache.path").String(); got != "$CACHEGOAT_BUILD_PATH" {
+		t.Errorf("Source(build_cache.path) = %q, want the environment variable", got)
+	}
+	if w := cfg.Warnings(); len(w) != 0 {
+		t.Errorf("unexpected warnings %q", w)
+	}
+}
+
+func TestLoadCaches(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("CACHEGOAT_CONFIG", "")
+	t.Setenv("GOMODCACHE", filepath.Join(home, "mod"))
+	t.Setenv("GOLANGCI_LINT_CACHE", filepath.Join(home, "lint"))
+	yaml := `
+caches:
+  - name: gopls
+    max_size: 1GiB
+  - name: buf
+    kind: dirs
+    path: ${UserCacheDir}/buf
+  - name: staticcheck
+    disabled: true
+`
+	if err := os.WriteFile(filepath.Join(home, fileName), []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]NamedCache)
+	for _, n := range cfg.AllCaches() {
+		got[n.Name] = n
+	}
+	cache := filepath.Join(home, ".cache")
+	for name, want := range map[string]string{
+		"build":         cfg.BuildCache.Path,
+		"mod":           filepath.Join(home, "mod"),
+		"gopls":         filepath.Join(cache, "gopls"),
+		"golangci-lint": filepath.Join(home, "lint"),
+		"buf":           filepath.Join(cache, "buf"),
+	} {
+		if got[name].Path != want {
+			t.Errorf("%s cache path = %q, want %q", name, got[name].Path, want)
+		}
+	}
+	if _, ok := got["staticcheck"]; ok {
+		t.Error("a disabled cache should be left out")
+	}
+	if g := got["gopls"]; g.MaxSize.Bytes != 1<<30 || g.MaxAge == 0 || g.Kind != KindFiles {
+		t.Errorf("gopls = %+v, want the built-in with max_size overridden", g)
+	}
+	if s := cfg.Source("caches[3].path").String(); s != "$GOLANGCI_LINT_CACHE" {
+		t.Errorf("golangci-lint path source = %q", s)
+	}
+
+	problems := loadProblems(t, "mod_cache:\n  path: /c/mod\ncaches:\n  - name: x\n    kind: tree\n    path: /\n  - kind: files\n    path: /tmp/y\n"+
+		"  - name: vcs\n    kind: dirs\n    path: ${GOMODCACHE}/cache/vcs\n")
+	want := []string{
+		`~/.cachegoat.yml:5:11: caches[0].kind: must be files or dirs, not "tree"`,
+		`~/.cachegoat.yml:6:11: caches[0].path: "/" is the root directory`,
+		"~/.cachegoat.yml:7:5: caches[1].name: is required",
+		`~/.cachegoat.yml:11:11: caches[2].path: "/c/mod/cache/vcs" overlaps mod_cache.path, which manages it already`,
+	}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("CACHEGOAT_CONFIG", "")
+	yaml := `
+build_cache:
+  max_size: 30GiB
+  strategy: purge
+mod_cache:
+  max_size: 10GiB
+profiles:
+  - name: go122
+    keep_warm: false
+    build_cache:
+      path: ~/go122/build
+      max_size: 5GiB
+`
+	file := filepath.Join(home, fileName)
+	if err := os.WriteFile(file, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.ProfileNames(); strings.Join(got, ",") != "default,go122" {
+		t.Errorf("ProfileNames() = %v", got)
+	}
+	if p, ok := cfg.Profile(DefaultProfile); !ok || p != cfg {
+		t.Error("the default profile should be the top-level config")
+	}
+	p, ok := cfg.Profile("go122")
+	if !ok {
+		t.Fatal("go122 profile missing")
+	}
+	b := p.BuildCache
+	if b.Path != filepath.Join(home, "go122", "build") || b.MaxSize.Bytes != 5<<30 || !b.Purge() || p.KeepWarm {
+		t.Errorf("build cache %+v keep_warm=%v, want the profile's path and size over the top-level strategy", b, p.KeepWarm)
+	}
+	if p.ModCache.Path != "" || len(p.Caches) != 0 {
+		t.Errorf("mod path %q and %d other caches, want neither", p.ModCache.Path, len(p.Caches))
+	}
+	if got := p.Source("build_cache.max_size").String(); got != file+":12" {
+		t.Errorf("Source(build_cache.max_size) = %q, want the profile's line", got)
+	}
+	if _, ok := cfg.Profile("nope"); ok {
+		t.Error("unknown profile found")
+	}
+
+	problems := loadProblems(t, "build_cache:\n  path: /c/build\nprofiles:\n  - name: default\n    build_cache:\n      path: /c/build\n")
+	want := []string{
+		`~/.cachegoat.yml:4:11: profiles[0].name: "default" names the top-level caches`,
+		`~/.cachegoat.yml:6:13: profiles[0].build_cache.path: "/c/build" is already build_cache.path`,
+	}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDiscoverCaches(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("CACHEGOAT_CONFIG", "")
+	for _, v := range []string{"GOCACHE", "GOMODCACHE", "CACHEGOAT_BUILD_PATH", "CACHEGOAT_MOD_PATH"} {
+		t.Setenv(v, "")
+	}
+	t.Setenv("GOPATH", filepath.Join(home, "gopath"))
+	t.Setenv("GOTOOLCHAIN", "auto")
+	goenv := filepath.Join(home, "goenv")
+	t.Setenv("GOENV", goenv)
+
+	modCache := filepath.Join(home, "gopath", "pkg", "mod")
+	toolchain := filepath.Join(modCache, "golang.org", "toolchain@v0.0.1-go1.99.0."+runtime.GOOS+"-"+runtime.GOARCH)
+	for _, dir := range []string{"build", "user-build", "tc-mod", "a", "b", "c"} {
+		if err := os.MkdirAll(filepath.Join(home, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(toolchain, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for file, data := range map[string]string{
+		goenv:                              "# set by go env -w\nGOCACHE=" + filepath.Join(home, "user-build") + "\n",
+		filepath.Join(toolchain, "go.env"): "GOMODCACHE=" + filepath.Join(home, "tc-mod") + "\n",
+		filepath.Join(home, "b", "go.mod"): "module b\n\ngo 1.21\n\ntoolchain go1.99.0\n",
+		filepath.Join(home, "c", "go.mod"): "module c\n\ngo 1.21\n",
+		filepath.Join(home, fileName): "build_cache:\n  path: ~/build\nmod_cache:\n  path: ~/gopath/pkg/mod\n" +
+			"protect_workspaces: [~/a, ~/b, ~/c]\n",
+	} {
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a uses the user's GOCACHE; b's toolchain adds its GOMODCACHE; c has
+	// nothing new.
+	if got := cfg.ProfileNames(); strings.Join(got, ",") != "default,a,b" {
+		t.Fatalf("ProfileNames() = %v, want default,a,b", got)
+	}
+	a, _ := cfg.Profile("a")
+	if a.BuildCache.Path != filepath.Join(home, "user-build") || a.ModCache.Path != "" {
+		t.Errorf("a: build %q, mod %q", a.BuildCache.Path, a.ModCache.Path)
+	}
+	if got := a.Source("build_cache.path").String(); got != goenv+":2" {
+		t.Errorf("a: Source(build_cache.path) = %q, want %s:2", got, goenv)
+	}
+	b, _ := cfg.Profile("b")
+	if b.BuildCache.Path != "" || b.ModCache.Path != filepath.Join(home, "tc-mod") {
+		t.Errorf("b: build %q, mod %q", b.BuildCache.Path, b.ModCache.Path)
+	}
+	if got, want := b.Source("mod_cache.path").String(), filepath.Join(toolchain, "go.env")+":1"; got != want {
+		t.Errorf("b: Source(mod_cache.path) = %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(home, fileName), []byte("discover_caches: false\nprotect_workspaces: [~/a]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = Load(); err != nil {
+		t.Fatal(err)
+	} else if len(cfg.Profiles) != 0 {
+		t.Errorf("discover_caches: false still discovered %v", cfg.ProfileNames())
+	}
+}
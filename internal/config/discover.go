@@ -0,0 +1,266 @@
+package config
+
+import (
+	"fmt"
+	"go/version"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// envFile is a go command environment file: the user's, which `go env -w`
+// writes, or a toolchain's go.env.
+type envFile struct {
+	path string
+	vars map[string]envValue
+}
+
+type envValue struct {
+	value string
+	line  int
+}
+
+// readEnvFile reads the environment file at path the way the go command
+// does: one KEY=VALUE per line, skipping blank lines and # comments. A file
+// that can't be read sets nothing.
+func readEnvFile(path string) envFile {
+	f := envFile{path: path, vars: make(map[string]envValue)}
+	if path == "" {
+		return f
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return f
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || key == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if v, err := strconv.Unquote(value); err == nil {
+			value = v
+		}
+		f.vars[key] = envValue{value: value, line: i + 1}
+	}
+	return f
+}
+
+// userEnvFile returns where the go command keeps the settings `go env -w`
+// makes: $GOENV, unless it's "off", or else go/env in the user's config
+// directory.
+func userEnvFile() string {
+	if f := os.Getenv("GOENV"); f != "" {
+		if f == "off" {
+			return ""
+		}
+		return f
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go", "env")
+}
+
+// goEnvironment resolves go command settings the way a go command whose
+// GOROOT holds root would, without running it: from the environment, then
+// the user's environment file, then the toolchain's go.env.
+type goEnvironment struct {
+	user, root envFile
+}
+
+// lookup returns the value of the setting name and where it came from, or
+// "" if it's unset.
+func (e goEnvironment) lookup(name string) (string, Source) {
+	if v := os.Getenv(name); v != "" {
+		return v, Source{Kind: SourceEnv, Var: name}
+	}
+	for _, f := range []envFile{e.user, e.root} {
+		if v, ok := f.vars[name]; ok && v.value != "" {
+			return v.value, Source{Kind: SourceFile, File: f.path, Line: v.line}
+		}
+	}
+	return "", Source{Kind: SourceDefault}
+}
+
+// cachePaths returns the build and module caches go would use, and where
+// each came from, falling back to go's own defaults: go-build in the user
+// cache directory, and pkg/mod in the first GOPATH entry, itself ~/go by
+// default. A cache go would use no cache for, or can't place, comes back "".
+func (e goEnvironment) cachePaths(home string) (build, mod string, buildSrc, modSrc Source) {
+	build, buildSrc = e.lookup("GOCACHE")
+	if build == "" {
+		if dir, err := os.UserCacheDir(); err == nil {
+			build = filepath.Join(dir, "go-build")
+		}
+	}
+	mod, modSrc = e.lookup("GOMODCACHE")
+	if mod == "" {
+		gopath, src := e.lookup("GOPATH")
+		gopath, _, _ = strings.Cut(gopath, string(filepath.ListSeparator))
+		if gopath == "" && home != "" {
+			gopath = filepath.Join(home, "go")
+		}
+		if gopath != "" {
+			mod, modSrc = filepath.Join(gopath, "pkg", "mod"), src
+		}
+	}
+	return absOnly(build), absOnly(mod), buildSrc, modSrc
+}
+
+// absOnly returns the absolute path p, cleaned, or "" for any other value,
+// such as GOCACHE=off, that go wouldn't use as a cache.
+func absOnly(p string) string {
+	if !filepath.IsAbs(p) {
+		return ""
+	}
+	return filepath.Clean(p)
+}
+
+// workspaceToolchain returns the Go version the go command would switch to
+// in dir under GOTOOLCHAIN=auto: the toolchain line, or else the go line, of
+// the go.work or go.mod governing dir. It returns "" if neither names one.
+func workspaceToolchain(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		for _, name := range []string{"go.work", "go.mod"} {
+			file := filepath.Join(d, name)
+			data, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			var goLine, toolchain string
+			if name == "go.work" {
+				if wf, err := modfile.ParseWork(file, data, nil); err == nil {
+					if wf.Go != nil {
+						goLine = wf.Go.Version
+					}
+					if wf.Toolchain != nil {
+						toolchain = wf.Toolchain.Name
+					}
+				}
+			} else {
+				// The lax parser, which copes with a file a newer Go wrote,
+				// drops the toolchain line, so it's only the fallback.
+				mf, err := modfile.Parse(file, data, nil)
+				if err != nil {
+					mf, err = modfile.ParseLax(file, data, nil)
+				}
+				if err != nil {
+					return ""
+				}
+				if mf.Go != nil {
+					goLine = mf.Go.Version
+				}
+				if mf.Toolchain != nil {
+					toolchain = mf.Toolchain.Name
+				}
+			}
+			if toolchain != "" {
+				return toolchain
+			}
+			if goLine != "" {
+				return "go" + goLine
+			}
+			return ""
+		}
+		if d == filepath.Dir(d) {
+			return ""
+		}
+	}
+}
+
+// toolchainRoot returns the GOROOT of the toolchain go would run in dir: the
+// local one, or the one GOTOOLCHAIN or the workspace's go.work or go.mod
+// selects, as downloaded into the module cache. A toolchain that hasn't been
+// downloaded, or would be found in PATH, counts as the local one.
+func (e goEnvironment) toolchainRoot(dir, modCache, localRoot, localVersion string) string {
+	gotoolchain, _ := e.lookup("GOTOOLCHAIN")
+	minVersion, mode, _ := strings.Cut(gotoolchain, "+")
+	switch minVersion {
+	case "", "auto", "path":
+		minVersion, mode = "", minVersion
+	case "local":
+		return localRoot
+	}
+	want := minVersion
+	if mode != "" {
+		// The workspace may ask for a newer toolchain, never an older one.
+		if v := workspaceToolchain(dir); version.Compare(v, want) > 0 {
+			want = v
+		}
+		if version.Compare(want, localVersion) <= 0 || mode == "path" {
+			return localRoot
+		}
+	}
+	if want == "" || want == localVersion || modCache == "" {
+		return localRoot
+	}
+	root := filepath.Join(modCache, "golang.org", fmt.Sprintf("toolchain@v0.0.1-%s.%s-%s", want, runtime.GOOS, runtime.GOARCH))
+	if _, err := os.Stat(filepath.Join(root, "go.env")); err != nil {
+		return localRoot
+	}
+	return root
+}
+
+// discoverCaches adds a profile for each pair of build and module caches the
+// go command would use in one of protect_workspaces that no profile manages
+// yet, going by the user's environment file and the go.env of the toolchain
+// each workspace selects rather than by `go env` run wherever cachegoat
+// happens to be started. A discovered cache that doesn't exist is left out.
+func (c *Config) discoverCaches(home string) {
+	if !c.DiscoverCaches || len(c.ProtectWorkspaces) == 0 {
+		return
+	}
+	known := map[string]bool{c.BuildCache.Path: true, c.ModCache.Path: true}
+	names := make(map[string]bool)
+	for _, p := range c.Profiles {
+		known[p.BuildCache.Path], known[p.ModCache.Path] = true, true
+		names[p.Name] = true
+	}
+
+	user := readEnvFile(userEnvFile())
+	localRoot, localVersion := goEnv("GOROOT"), goEnv("GOVERSION")
+	local := goEnvironment{user: user}
+	if localRoot != "" {
+		local.root = readEnvFile(filepath.Join(localRoot, "go.env"))
+	}
+	_, localMod, _, _ := local.cachePaths(home)
+	for _, ws := range c.ProtectWorkspaces {
+		env := local
+		if root := local.toolchainRoot(ws, localMod, localRoot, localVersion); root != localRoot {
+			env.root = readEnvFile(filepath.Join(root, "go.env"))
+		}
+		build, mod, buildSrc, modSrc := env.cachePaths(home)
+		var p Profile
+		for _, d := range []struct {
+			path string
+			src  Source
+			dst  *CacheConfig
+			key  string
+		}{
+			{build, buildSrc, &p.BuildCache, "build_cache.path"},
+			{mod, modSrc, &p.ModCache, "mod_cache.path"},
+		} {
+			if _, err := os.Stat(d.path); d.path == "" || known[d.path] || err != nil {
+				continue
+			}
+			known[d.path] = true
+			d.dst.Path = d.path
+			c.derived[fmt.Sprintf("profiles[%d].%s", len(c.Profiles), d.key)] = d.src
+		}
+		if p.BuildCache.Path == "" && p.ModCache.Path == "" {
+			continue
+		}
+		p.Name = filepath.Base(ws)
+		for i := 2; names[p.Name] || p.Name == DefaultProfile; i++ {
+			p.Name = fmt.Sprintf("%s-%d", filepath.Base(ws), i)
+		}
+		names[p.Name] = true
+		c.Profiles = append(c.Profiles, p)
+	}
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const day = 24 * time.Hour
+
+// Duration is a time.Duration that reads from and writes to YAML as a string.
+// Besides Go duration syntax ("36h"), it accepts whole or fractional days and
+// weeks ("30d", "2w"), which is how cache ages are naturally expressed.
+type Duration time.Duration
+
+// ParseDuration parses a duration in Go syntax or as a number of days ("d")
+// or weeks ("w").
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	for suffix, unit := range map[string]time.Duration{"d": day, "w": 7 * day} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			f, err := strconv.ParseFloat(n, 64)
+			if err != nil || math.IsNaN(f) || math.Abs(f*float64(unit)) >= math.MaxInt64 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(f * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	v, err := ParseDuration(n.Value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (any, error) {
+	return d.String(), nil
+}
+
+// String formats whole days as "Nd" and anything else in Go syntax.
+func (d Duration) String() string {
+	td := time.Duration(d)
+	if td != 0 && td%day == 0 {
+		return fmt.Sprintf("%dd", td/day)
+	}
+	return td.String()
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SetFileValue sets key, a dotted path such as "build_cache.max_size", to
+// value in the YAML config file, creating the file and any missing sections as
+// needed. Comments and every other setting are kept, as is the file's mode.
+func SetFileValue(file, key, value string) error {
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	var doc yaml.Node
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	m := doc.Content[0]
+
+	keys := strings.Split(key, ".")
+	for i, k := range keys {
+		if m.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s: %s is not a mapping", file, strings.Join(keys[:i], "."))
+		}
+		var child *yaml.Node
+		for j := 0; j+1 < len(m.Content); j += 2 {
+			if m.Content[j].Value == k {
+				child = m.Content[j+1]
+			}
+		}
+		last := i == len(keys)-1
+		switch {
+		case child == nil && last:
+			child = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+			m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, child)
+		case child == nil:
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, child)
+		case last:
+			child.Kind, child.Tag, child.Style, child.Value, child.Content = yaml.ScalarNode, "!!str", 0, value, nil
+		}
+		m = child
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf.Bytes(), mode)
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// fileName is the user's config file, in their home directory.
+const fileName = ".cachegoat.yml"
+
+// systemFile holds defaults an administrator sets for every user on the
+// machine. The user's own config file is merged over it.
+var systemFile = "/etc/cachegoat.yml"
+
+// fileOverride is the user's config file as set by SetFile.
+var fileOverride string
+
+// SetFile makes file the user's config file, in place of $CACHEGOAT_CONFIG or
+// the default locations, as --config-file does.
+func SetFile(file string) {
+	fileOverride = file
+}
+
+// File returns the path of the user's config file, which may not exist yet:
+// the one set by SetFile or $CACHEGOAT_CONFIG, or else
+// $XDG_CONFIG_HOME/cachegoat/config.yml (~/.config/cachegoat/config.yml) if
+// it exists, or else ~/.cachegoat.yml.
+func File() string {
+	file, _ := userFile()
+	return file
+}
+
+// userFile returns the user's config file, and whether it was chosen
+// explicitly, in which case it must exist.
+func userFile() (file string, explicit bool) {
+	if fileOverride != "" {
+		return fileOverride, true
+	}
+	if file := os.Getenv("CACHEGOAT_CONFIG"); file != "" {
+		return file, true
+	}
+	home, _ := os.UserHomeDir()
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" && home != "" {
+		dir = filepath.Join(home, ".config")
+	}
+	if dir != "" {
+		xdg := filepath.Join(dir, "cachegoat", "config.yml")
+		if _, err := os.Stat(xdg); err == nil {
+			return xdg, false
+		}
+	}
+	return filepath.Join(home, fileName), false
+}
+
+// Files returns the config files Load reads, lowest precedence first: the
+// system-wide file, then the user's. Either may not exist.
+func Files() []string {
+	return []string{systemFile, File()}
+}
+
+// ReadFiles returns the config files Load read, lowest precedence first.
+func (c *Config) ReadFiles() []string {
+	return c.files
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// DefaultProfile names the build and module caches configured at the top
+// level, along with the other caches.
+const DefaultProfile = "default"
+
+// Profile is another pair of Go build and module caches to manage alongside
+// the top-level ones, such as another user's, another toolchain's, or a
+// project's set with `go env -w`. Its caches take their settings from the
+// top-level build_cache and mod_cache, overridden one by one, except for
+// their paths: a cache the profile gives no path isn't managed in it.
+type Profile struct {
+	Name       string      `yaml:"name"`
+	BuildCache CacheConfig `yaml:"build_cache"`
+	ModCache   CacheConfig `yaml:"mod_cache"`
+
+	// KeepWarm, when set, overrides keep_warm for the profile's caches.
+	KeepWarm *bool `yaml:"keep_warm,omitempty"`
+}
+
+// ProfileNames returns the name of every profile, DefaultProfile first.
+func (c *Config) ProfileNames() []string {
+	names := []string{DefaultProfile}
+	for _, p := range c.Profiles {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// Profile returns the configuration of the named profile, and whether there
+// is one. DefaultProfile's is c itself. Any other's has the profile's build
+// and module caches and no other caches.
+func (c *Config) Profile(name string) (*Config, bool) {
+	if name == DefaultProfile {
+		return c, true
+	}
+	i := slices.IndexFunc(c.Profiles, func(p Profile) bool { return p.Name == name })
+	if i < 0 {
+		return nil, false
+	}
+	p := c.Profiles[i]
+	pc := *c
+	pc.BuildCache = c.BuildCache.overlay(p.BuildCache)
+	pc.BuildCache.Path = p.BuildCache.Path
+	pc.ModCache = c.ModCache.overlay(p.ModCache)
+	pc.ModCache.Path = p.ModCache.Path
+	if p.KeepWarm != nil {
+		pc.KeepWarm = *p.KeepWarm
+	}
+	pc.Caches, pc.Profiles = nil, nil
+
+	// Sources follow: the profile's settings are the ones at its keys.
+	pc.positions, pc.derived = maps.Clone(c.positions), maps.Clone(c.derived)
+	for _, k := range []string{"build_cache.path", "mod_cache.path"} {
+		delete(pc.positions, k)
+		delete(pc.derived, k)
+	}
+	prefix := fmt.Sprintf("profiles[%d].", i)
+	for k, pos := range c.positions {
+		if rest, ok := strings.CutPrefix(k, prefix); ok {
+			pc.positions[rest] = pos
+		}
+	}
+	for k, src := range c.derived {
+		if rest, ok := strings.CutPrefix(k, prefix); ok {
+			pc.derived[rest] = src
+		}
+	}
+	return &pc, true
+}
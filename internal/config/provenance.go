@@ -0,0 +1,117 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Kinds of Source.
+const (
+	SourceDefault = "default" // cachegoat's built-in default
+	SourceFile    = "file"    // a config file
+	SourceGoEnv   = "go env"  // the go command's own setting
+	SourceEnv     = "env"     // an environment variable
+)
+
+// Source is where a resolved configuration value came from.
+type Source struct {
+	Kind string
+	File string // for SourceFile
+	Line int    // for SourceFile
+	Var  string // the variable read, for SourceGoEnv and SourceEnv
+}
+
+func (s Source) String() string {
+	switch s.Kind {
+	case SourceFile:
+		return fmt.Sprintf("%s:%d", s.File, s.Line)
+	case SourceGoEnv:
+		return "go env " + s.Var
+	case SourceEnv:
+		return "$" + s.Var
+	}
+	return SourceDefault
+}
+
+// Source returns where the value of key, a dotted key such as
+// "build_cache.max_size", came from.
+func (c *Config) Source(key string) Source {
+	if s, ok := c.derived[key]; ok {
+		return s
+	}
+	if pos, ok := c.positions[key]; ok {
+		return Source{Kind: SourceFile, File: pos.file, Line: pos.line}
+	}
+	return Source{Kind: SourceDefault}
+}
+
+// Annotated renders the configuration as YAML like String, headed by the
+// config files read and noting beside each value where it came from.
+func (c *Config) Annotated() string {
+	var buf bytes.Buffer
+	if len(c.files) == 0 {
+		buf.WriteString("# No config file; using defaults\n")
+	} else {
+		fmt.Fprintf(&buf, "# Config files, lowest precedence first: %s\n", strings.Join(c.files, ", "))
+	}
+
+	var doc yaml.Node
+	if err := doc.Encode(c); err != nil {
+		return buf.String() + c.String()
+	}
+	c.annotate(&doc, "")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	_ = enc.Encode(&doc)
+	return buf.String()
+}
+
+// annotate comments each value under the mapping n, whose dotted key is key,
+// with where it came from.
+func (c *Config) annotate(n *yaml.Node, key string) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		child := k.Value
+		if key != "" {
+			child = key + "." + k.Value
+		}
+		if v.Kind == yaml.MappingNode {
+			c.annotate(v, child)
+			continue
+		}
+		if v.Kind == yaml.SequenceNode && len(v.Content) > 0 && v.Content[0].Kind == yaml.MappingNode {
+			for j, item := range v.Content {
+				c.annotate(item, fmt.Sprintf("%s[%d]", child, j))
+			}
+			continue
+		}
+		k.LineComment = c.Source(child).String()
+	}
+}
+
+// Warnings returns what looks wrong with the resolved configuration without
+// making it invalid: a cache path that isn't the one the go command uses, so
+// cachegoat would manage a cache Go doesn't fill while Go's own grows
+// unchecked.
+func (c *Config) Warnings() []string {
+	var warnings []string
+	for _, p := range []struct{ key, envVar, path string }{
+		{"build_cache.path", "GOCACHE", c.BuildCache.Path},
+		{"mod_cache.path", "GOMODCACHE", c.ModCache.Path},
+	} {
+		goPath := goEnv(p.envVar)
+		if p.path == "" || goPath == "" || filepath.Clean(p.path) == filepath.Clean(goPath) {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s is %s (from %s), but go env %s is %s: cachegoat is managing a cache Go isn't using",
+			p.key, p.path, c.Source(p.key), p.envVar, goPath))
+	}
+	return warnings
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sizeUnits maps the accepted size suffixes to their multipliers. Decimal-
+// looking units are binary too, matching both max_size_gb and what du reports.
+var sizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	// Longest suffixes first, so "GiB" isn't mistaken for "B".
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"tb", 1 << 40},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30}, {"t", 1 << 40},
+	{"b", 1},
+}
+
+// Size is a cache size: either an absolute number of bytes or a percentage of
+// the filesystem holding the cache. The zero Size means "not set".
+type Size struct {
+	Bytes   int64
+	Percent float64
+}
+
+// ParseSize parses sizes like "512MB", "25GiB", "1.5g", "1048576" (bytes) or
+// "10%" (of the filesystem).
+func ParseSize(s string) (Size, error) {
+	in := strings.ToLower(strings.TrimSpace(s))
+	if n, ok := strings.CutSuffix(in, "%"); ok {
+		p, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil || math.IsNaN(p) || p <= 0 || p > 100 {
+			return Size{}, fmt.Errorf("invalid size %q: percentage must be between 0 and 100", s)
+		}
+		return Size{Percent: p}, nil
+	}
+
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if n, ok := strings.CutSuffix(in, u.suffix); ok {
+			in, mult = strings.TrimSpace(n), u.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(in, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return Size{}, fmt.Errorf("invalid size %q", s)
+	}
+	if f < 0 {
+		return Size{}, fmt.Errorf("invalid size %q: must not be negative", s)
+	}
+	if f*float64(mult) >= math.MaxInt64 {
+		return Size{}, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return Size{Bytes: int64(f * float64(mult))}, nil
+}
+
+func (s Size) IsZero() bool { return s.Bytes == 0 && s.Percent == 0 }
+
+// Resolve returns the size in bytes for a cache on a filesystem of fsSize
+// bytes. A percentage of an unknown (zero) filesystem size resolves to -1.
+func (s Size) Resolve(fsSize int64) int64 {
+	if s.Percent == 0 {
+		return s.Bytes
+	}
+	if fsSize <= 0 {
+		return -1
+	}
+	return int64(float64(fsSize) * s.Percent / 100)
+}
+
+// String formats the size with the largest binary unit that keeps it readable.
+func (s Size) String() string {
+	if s.Percent != 0 {
+		return strconv.FormatFloat(s.Percent, 'f', -1, 64) + "%"
+	}
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}} {
+		if s.Bytes >= u.mult {
+			n := strconv.FormatFloat(float64(s.Bytes)/float64(u.mult), 'f', 2, 64)
+			return strings.TrimSuffix(strings.TrimRight(n, "0"), ".") + u.suffix
+		}
+	}
+	return strconv.FormatInt(s.Bytes, 10) + "B"
+}
+
+func (s *Size) UnmarshalYAML(n *yaml.Node) error {
+	v, err := ParseSize(n.Value)
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+func (s Size) MarshalYAML() (any, error) {
+	return s.String(), nil
+}
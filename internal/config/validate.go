@@ -0,0 +1,437 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is one thing wrong with the configuration. Line and Column locate it
+// in File when it came from the config file, and are 0 otherwise, such as for
+// a path taken from the environment.
+type Problem struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Key     string `json:"key,omitempty"` // dotted, such as "build_cache.max_size"
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.File != "" {
+		b.WriteString(p.File)
+		if p.Line > 0 {
+			fmt.Fprintf(&b, ":%d", p.Line)
+			if p.Column > 0 {
+				fmt.Fprintf(&b, ":%d", p.Column)
+			}
+		}
+		b.WriteString(": ")
+	}
+	if p.Key != "" {
+		b.WriteString(p.Key + ": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// ValidationError is returned by Load when the configuration has problems.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.String()
+	}
+	if len(lines) == 1 {
+		return "invalid configuration: " + lines[0]
+	}
+	return "invalid configuration:\n  " + strings.Join(lines, "\n  ")
+}
+
+// Problems returns the configuration problems err reports, if it's a
+// *ValidationError.
+func Problems(err error) []Problem {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return verr.Problems
+	}
+	return nil
+}
+
+// position is where a value sits in a config file.
+type position struct {
+	file         string
+	line, column int
+}
+
+// syntaxLine pulls the line number out of a YAML syntax error, which carries
+// no column.
+var syntaxLine = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// decodeStrict decodes the config file data into cfg, rejecting unknown keys
+// and values of the wrong type with their line and column. It returns where
+// each key's value sits, by dotted key, for locating later problems.
+func decodeStrict(file string, data []byte, cfg *Config) (map[string]position, []Problem) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		p := Problem{File: file, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if m := syntaxLine.FindStringSubmatch(err.Error()); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+			p.Message = strings.TrimPrefix(err.Error(), m[0])
+		}
+		return nil, []Problem{p}
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil // empty file
+	}
+
+	positions := make(map[string]position)
+	var problems []Problem
+	checkNode(doc.Content[0], reflect.TypeOf(*cfg), "", func(n *yaml.Node, key, msg string) {
+		problems = append(problems, Problem{File: file, Line: n.Line, Column: n.Column, Key: key, Message: msg})
+	}, positions)
+	if len(problems) > 0 {
+		return positions, problems
+	}
+	for k, pos := range positions {
+		pos.file = file
+		positions[k] = pos
+	}
+	if err := doc.Content[0].Decode(cfg); err != nil {
+		return positions, []Problem{{File: file, Message: err.Error()}}
+	}
+	return positions, nil
+}
+
+var unmarshalerType = reflect.TypeFor[yaml.Unmarshaler]()
+
+// checkNode checks n against the Go type t it will be decoded into, reporting
+// each unknown key or badly typed value, and records the position of every
+// value by its dotted key.
+func checkNode(n *yaml.Node, t reflect.Type, key string, report func(n *yaml.Node, key, msg string), positions map[string]position) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if key != "" {
+		positions[key] = position{line: n.Line, column: n.Column}
+	}
+	if n.Tag == "!!null" {
+		return
+	}
+
+	switch {
+	case reflect.PointerTo(t).Implements(unmarshalerType) || t.Kind() != reflect.Struct && t.Kind() != reflect.Slice:
+		if err := n.Decode(reflect.New(t).Interface()); err != nil {
+			report(n, key, decodeMessage(err))
+		}
+
+	case t.Kind() == reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			report(n, key, "expected a list")
+			return
+		}
+		for i, item := range n.Content {
+			checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", key, i), report, positions)
+		}
+
+	default: // struct
+		if n.Kind != yaml.MappingNode {
+			report(n, key, "expected a mapping")
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			child := k.Value
+			if key != "" {
+				child = key + "." + k.Value
+			}
+			f, ok := fields[k.Value]
+			if !ok {
+				msg := "unknown field"
+				if s := suggest(k.Value, fields); s != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", s)
+				}
+				report(k, child, msg)
+				continue
+			}
+			checkNode(v, f, child, report, positions)
+		}
+	}
+}
+
+// yamlFields maps each YAML key of struct type t to its field's type.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if opts == "inline" {
+			maps.Copy(fields, yamlFields(f.Type))
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// decodeMessage strips the line prefix yaml.v3 puts on type errors, which
+// Problem reports separately.
+func decodeMessage(err error) string {
+	var terr *yaml.TypeError
+	if errors.As(err, &terr) && len(terr.Errors) > 0 {
+		msg := terr.Errors[0]
+		if _, rest, ok := strings.Cut(msg, ": "); ok && strings.HasPrefix(msg, "line ") {
+			msg = rest
+		}
+		return msg
+	}
+	return err.Error()
+}
+
+// suggest returns the known key closest to an unknown one, if any is close
+// enough to be a likely typo.
+func suggest(key string, fields map[string]reflect.Type) string {
+	best, bestDist := "", 3
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		if d := editDistance(key, name); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// validate checks the resolved configuration's values, reporting problems by
+// dotted key.
+func (c *Config) validate(home string) []Problem {
+	var problems []Problem
+	add := func(key, format string, args ...any) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	type keyed struct {
+		key string
+		cc  CacheConfig
+	}
+	caches := []keyed{{"build_cache", c.BuildCache}, {"mod_cache", c.ModCache}}
+	names := make(map[string]bool)
+	for i, n := range c.Caches {
+		key := fmt.Sprintf("caches[%d]", i)
+		caches = append(caches, keyed{key, n.CacheConfig})
+		switch {
+		case n.Name == "":
+			add(key+".name", "is required")
+		case names[n.Name]:
+			add(key+".name", "%q is listed twice", n.Name)
+		}
+		names[n.Name] = true
+		if n.Kind != KindFiles && n.Kind != KindDirs {
+			add(key+".kind", "must be %s or %s, not %q", KindFiles, KindDirs, n.Kind)
+		}
+		if _, err := filepath.Match(n.Entries, ""); err != nil {
+			add(key+".entries", "invalid glob %q", n.Entries)
+		}
+	}
+	profiles := map[string]bool{DefaultProfile: true}
+	goCaches := caches[:2:2]
+	for i, p := range c.Profiles {
+		key := fmt.Sprintf("profiles[%d]", i)
+		switch {
+		case p.Name == "":
+			add(key+".name", "is required")
+		case p.Name == DefaultProfile:
+			add(key+".name", "%q names the top-level caches", p.Name)
+		case profiles[p.Name]:
+			add(key+".name", "%q is listed twice", p.Name)
+		}
+		profiles[p.Name] = true
+		pcs := []keyed{{key + ".build_cache", p.BuildCache}, {key + ".mod_cache", p.ModCache}}
+		caches = append(caches, pcs...)
+		goCaches = append(goCaches, pcs...)
+	}
+	// Two profiles sharing a cache would clean it twice.
+	seen := make(map[string]string)
+	for _, cc := range goCaches {
+		if cc.cc.Path == "" {
+			continue
+		}
+		if other, ok := seen[filepath.Clean(cc.cc.Path)]; ok {
+			add(cc.key+".path", "%q is already %s.path", cc.cc.Path, other)
+		}
+		seen[filepath.Clean(cc.cc.Path)] = cc.key
+	}
+
+	for _, cc := range caches {
+		if cc.cc.MaxSizeGB < 0 {
+			add(cc.key+".max_size_gb", "must not be negative")
+		}
+		if cc.cc.TargetSizeGB < 0 {
+			add(cc.key+".target_size_gb", "must not be negative")
+		}
+		if cc.cc.MaxAge < 0 {
+			add(cc.key+".max_age", "must not be negative")
+		}
+		if cc.cc.KeepVersions < 0 {
+			add(cc.key+".keep_versions", "must not be negative")
+		}
+		if s := cc.cc.Strategy; s != "" && s != StrategyTrim && s != StrategyPurge {
+			add(cc.key+".strategy", "must be %s or %s, not %q", StrategyTrim, StrategyPurge, s)
+		}
+		if m := cc.cc.Measure; m != "" && m != MeasureAllocated && m != MeasureApparent {
+			add(cc.key+".measure", "must be %s or %s, not %q", MeasureAllocated, MeasureApparent, m)
+		}
+		for i, p := range cc.cc.KeepReferenced {
+			if msg := checkPath(p, home, false); msg != "" {
+				add(fmt.Sprintf("%s.keep_referenced[%d]", cc.key, i), "%s", msg)
+			}
+		}
+	}
+	if c.ProtectBuildsWait < 0 {
+		add("protect_builds_wait", "must not be negative")
+	}
+	if c.MaxDeferrals < 0 {
+		add("max_deferrals", "must not be negative")
+	}
+
+	// cachegoat deletes inside cache paths and creates directories under
+	// relocate_to, so those must never be the home or root directory.
+	for _, p := range []struct {
+		key, path string
+		dangerous bool
+	}{
+		{"build_cache.path", c.BuildCache.Path, true},
+		{"mod_cache.path", c.ModCache.Path, true},
+		{"relocate_to", c.RelocateTo, true},
+		{"state_dir", c.StateDir, false},
+		{"log_path", c.LogPath, false},
+	} {
+		if msg := checkPath(p.path, home, p.dangerous); msg != "" {
+			add(p.key, "%s", msg)
+		}
+	}
+	for _, cc := range goCaches[2:] {
+		if msg := checkPath(cc.cc.Path, home, true); msg != "" {
+			add(cc.key+".path", "%s", msg)
+		}
+	}
+	for i, n := range c.Caches {
+		if msg := checkPath(n.Path, home, true); msg != "" {
+			add(fmt.Sprintf("caches[%d].path", i), "%s", msg)
+			continue
+		}
+		// Go's own caches manage everything inside them, with the care Go
+		// needs, such as module versions evicted only whole.
+		for _, g := range []keyed{{"build_cache", c.BuildCache}, {"mod_cache", c.ModCache}} {
+			if n.Disabled || n.Path == "" || g.cc.Path == "" || checkPath(g.cc.Path, home, true) != "" {
+				continue
+			}
+			if overlaps(n.Path, g.cc.Path) {
+				add(fmt.Sprintf("caches[%d].path", i), "%q overlaps %s.path, which manages it already", n.Path, g.key)
+			}
+		}
+	}
+	for i, cmd := range c.ProtectCommands {
+		words := strings.Fields(cmd)
+		if len(words) == 0 {
+			add(fmt.Sprintf("protect_commands[%d]", i), "empty command")
+		}
+		for _, w := range words {
+			if _, err := filepath.Match(w, ""); err != nil {
+				add(fmt.Sprintf("protect_commands[%d]", i), "%q is not a valid glob", w)
+				break
+			}
+		}
+	}
+	for i, p := range c.ProtectWorkspaces {
+		if msg := checkPath(p, home, false); msg != "" {
+			add(fmt.Sprintf("protect_workspaces[%d]", i), "%s", msg)
+		}
+	}
+	return problems
+}
+
+// overlaps reports whether either of the paths a and b is inside the other.
+func overlaps(a, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	inside := func(p, dir string) bool {
+		return p == dir || strings.HasPrefix(p, dir+string(filepath.Separator))
+	}
+	return inside(a, b) || inside(b, a)
+}
+
+// checkPath returns what's wrong with a configured path, or "" if nothing is.
+// An empty path is fine; it means "unset". A dangerous path is one cachegoat
+// deletes within, which must not be the root or home directory.
+func checkPath(p, home string, dangerous bool) string {
+	if p == "" {
+		return ""
+	}
+	if !filepath.IsAbs(p) {
+		return fmt.Sprintf("%q is not an absolute path", p)
+	}
+	if !dangerous {
+		return ""
+	}
+	clean := filepath.Clean(p)
+	if clean == filepath.Dir(clean) {
+		return fmt.Sprintf("%q is the root directory", p)
+	}
+	if home != "" && clean == filepath.Clean(home) {
+		return fmt.Sprintf("%q is your home directory", p)
+	}
+	return ""
+}
+
+// locate fills in where each problem's key was set, for keys set in a config
+// file. A problem with a field of a list item that wasn't set, such as a
+// missing name, is placed at the item.
+func locate(problems []Problem, positions map[string]position) {
+	for i, p := range problems {
+		pos, ok := positions[p.Key]
+		if item, _, found := strings.Cut(p.Key, "]."); !ok && found {
+			pos, ok = positions[item+"]"]
+		}
+		if ok {
+			problems[i].File, problems[i].Line, problems[i].Column = pos.file, pos.line, pos.column
+		}
+	}
+}
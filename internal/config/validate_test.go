@@ -0,0 +1,96 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadProblems writes yaml as the config file in a fresh home directory and
+// returns the problems Load reports, formatted, with the home directory
+// replaced by "~".
+func loadProblems(t *testing.T, yaml string) []string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.WriteFile(filepath.Join(home, fileName), []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load()
+	if err == nil {
+		t.Fatalf("expected problems, got config:\n%s", cfg)
+	}
+	var got []string
+	for _, p := range Problems(err) {
+		got = append(got, strings.ReplaceAll(p.String(), home, "~"))
+	}
+	if len(got) == 0 {
+		t.Fatalf("expected a *ValidationError, got %v", err)
+	}
+	return got
+}
+
+func TestLoadStrict(t *testing.T) {
+	for _, tc := range []struct {
+		name, yaml string
+		want       []string
+	}{
+		{
+			"unknown field",
+			"build_cache:\n  max_sise_gb: 50\n",
+			[]string{`~/.cachegoat.yml:2:3: build_cache.max_sise_gb: unknown field (did you mean "max_size_gb"?)`},
+		},
+		{
+			"wrong type",
+			"keep_warm: true\nmod_cache:\n  keep_versions: lots\n",
+			[]string{"~/.cachegoat.yml:3:18: mod_cache.keep_versions: cannot unmarshal !!str `lots` into int"},
+		},
+		{
+			"tab indentation",
+			"build_cache:\n\tmax_size: 10GiB\n",
+			[]string{"~/.cachegoat.yml:2: found character that cannot start any token"},
+		},
+		{
+			"bad values",
+			"build_cache:\n  max_size: -5GB\n",
+			[]string{`~/.cachegoat.yml:2:13: build_cache.max_size: invalid size "-5GB": must not be negative`},
+		},
+		{
+			"non-finite size",
+			"mod_cache:\n  max_size: inf\n  target_size: nan GiB\n",
+			[]string{
+				`~/.cachegoat.yml:2:13: mod_cache.max_size: invalid size "inf"`,
+				`~/.cachegoat.yml:3:16: mod_cache.target_size: invalid size "nan GiB"`,
+			},
+		},
+		{
+			"dangerous and relative paths",
+			"build_cache:\n  path: /\n  strategy: wipe\nmod_cache:\n  path: ~/\nstate_dir: state\n",
+			[]string{
+				`~/.cachegoat.yml:2:9: build_cache.path: "/" is the root directory`,
+				`~/.cachegoat.yml:3:13: build_cache.strategy: must be trim or purge, not "wipe"`,
+				`~/.cachegoat.yml:5:9: mod_cache.path: "~" is your home directory`,
+				`~/.cachegoat.yml:6:12: state_dir: "state" is not an absolute path`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := loadProblems(t, tc.yaml)
+			if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestLoadValidatesEnvironment(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("CACHEGOAT_BUILD_PATH", "/")
+
+	_, err := Load()
+	problems := Problems(err)
+	if len(problems) != 1 || problems[0].String() != `build_cache.path: "/" is the root directory` {
+		t.Errorf("got %v", err)
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/YakDriver/cachegoat/internal/cleaner"
 	"github.com/YakDriver/cachegoat/internal/config"
@@ -12,36 +17,137 @@ import (
 func main() {
 	dryRun := flag.Bool("dry-run", false, "show what would be cleaned without deleting")
 	force := flag.Bool("force", false, "run even if Go build is active")
-	showConfig := flag.Bool("config", false, "show resolved configuration")
+	showConfig := flag.Bool("config", false, "show resolved configuration and which config file set each value")
+	configFile := flag.String("config-file", "", "read this config file instead of ~/.cachegoat.yml (default $CACHEGOAT_CONFIG)")
+	validateConfig := flag.Bool("validate-config", false, "check the configuration and exit non-zero on problems")
 	recommend := flag.Bool("recommend", false, "show setup recommendations")
 	schedule := flag.Bool("schedule", false, "create and enable scheduled cleanup")
 	unschedule := flag.Bool("unschedule", false, "remove scheduled cleanup")
 	showVersion := flag.Bool("version", false, "print version and exit")
+	output := flag.String("output", "text", "output format: text or json")
+	apply := flag.String("apply", "", "with --recommend: comma-separated finding IDs to fix without asking ("+strings.Join(cleaner.FindingIDs, ", ")+")")
+	yes := flag.Bool("yes", false, "with --recommend: fix every finding without asking; with --migrate: delete the old caches")
+	revert := flag.Bool("revert", false, "with --recommend: remove cachegoat's block from your shell profiles")
+	migrate := flag.Bool("migrate", false, "move existing cache contents to their relocated paths")
+	profile := flag.String("profile", "", "clean, or with --config show, only this profile's caches")
+	lockTimeout := flag.String("lock-timeout", "", "wait this long (e.g. 5m) for another cachegoat run to finish with a cache before skipping it (default: skip it at once)")
+	wait := flag.String("wait", "", "wait this long (e.g. 30m) for running builds to finish before skipping cleanup (default protect_builds_wait)")
 	flag.Parse()
 
+	switch *output {
+	case "text":
+	case "json":
+		jsonOutput = true
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown --output %q (want text or json)\n", *output)
+		os.Exit(2)
+	}
+
+	var applyIDs []string
+	if *apply != "" {
+		for id := range strings.SplitSeq(*apply, ",") {
+			id = strings.TrimSpace(id)
+			if !slices.Contains(cleaner.FindingIDs, id) {
+				fmt.Fprintf(os.Stderr, "error: unknown finding %q in --apply (want %s)\n", id, strings.Join(cleaner.FindingIDs, ", "))
+				os.Exit(2)
+			}
+			applyIDs = append(applyIDs, id)
+		}
+	}
+
+	durations := make(map[string]time.Duration)
+	for name, v := range map[string]string{"wait": *wait, "lock-timeout": *lockTimeout} {
+		if v == "" {
+			continue
+		}
+		d, err := config.ParseDuration(v)
+		if err != nil || d < 0 {
+			fmt.Fprintf(os.Stderr, "error: invalid --%s %q (want a duration such as 30m)\n", name, v)
+			os.Exit(2)
+		}
+		durations[name] = d
+	}
+
+	// In JSON mode stdout carries only the JSON document; progress goes to
+	// stderr.
+	progress := io.Writer(os.Stdout)
+	if jsonOutput {
+		progress = os.Stderr
+	}
+
 	if *showVersion {
+		if jsonOutput {
+			emit(map[string]string{"version": version()})
+			return
+		}
 		fmt.Println(version())
 		return
 	}
 
+	if *configFile != "" {
+		config.SetFile(*configFile)
+	}
 	cfg, err := config.Load()
+	if *validateConfig {
+		validate(err)
+		return
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
-		os.Exit(1)
+		fail("error loading config", err)
+	}
+	if *profile != "" {
+		if _, ok := cfg.Profile(*profile); !ok {
+			fail("error", fmt.Errorf("unknown profile %q (want %s)", *profile, strings.Join(cfg.ProfileNames(), ", ")))
+		}
 	}
 
 	if *schedule {
-		if err := cleaner.Schedule(); err != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			os.Exit(1)
+		if err := cleaner.Schedule(progress); err != nil {
+			fail("error", err)
+		}
+		if jsonOutput {
+			emit(map[string]bool{"scheduled": true})
 		}
 		return
 	}
 
 	if *unschedule {
-		if err := cleaner.Unschedule(); err != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			os.Exit(1)
+		if err := cleaner.Unschedule(progress); err != nil {
+			fail("error", err)
+		}
+		if jsonOutput {
+			emit(map[string]bool{"scheduled": false})
+		}
+		return
+	}
+
+	if *migrate {
+		opts := cleaner.MigrateOptions{Yes: *yes, Out: progress}
+		if !jsonOutput && !*yes {
+			opts.In = os.Stdin
+		}
+		ms, err := cleaner.Migrate(cfg, opts)
+		if jsonOutput {
+			if err != nil {
+				emit(map[string]any{"migrations": ms, "error": err.Error()})
+				os.Exit(1)
+			}
+			emit(map[string]any{"migrations": append([]*cleaner.Migration{}, ms...)})
+			return
+		}
+		if err != nil {
+			fail("error", err)
+		}
+		return
+	}
+
+	if *recommend && *revert {
+		files, err := cleaner.RevertProfiles(progress)
+		if err != nil {
+			fail("error", err)
+		}
+		if jsonOutput {
+			emit(map[string][]string{"reverted": append([]string{}, files...)})
 		}
 		return
 	}
@@ -49,22 +155,127 @@ func main() {
 	if *recommend {
 		// The update check only runs here (not on every invocation), so
 		// scheduled and routine runs stay silent and offline.
-		if msg := updateNotice(); msg != "" {
-			fmt.Println(msg)
-			fmt.Println()
+		msg := updateNotice()
+		if msg != "" {
+			_, _ = fmt.Fprintf(progress, "%s\n\n", msg)
+		}
+		// Only prompt when nothing was decided on the command line, so
+		// provisioning scripts never block on stdin.
+		unattended := jsonOutput || *yes || len(applyIDs) > 0
+		opts := cleaner.RecommendOptions{Apply: applyIDs, Yes: *yes, Out: progress}
+		if !unattended {
+			opts.In = os.Stdin
+		}
+		r := cleaner.Recommend(cfg, opts)
+		if jsonOutput {
+			emit(struct {
+				Update string `json:"update,omitempty"`
+				*cleaner.Recommendations
+			}{msg, r})
+		}
+		if unattended && len(r.Unresolved()) > 0 {
+			os.Exit(exitUnresolved)
 		}
-		cleaner.Recommend(cfg)
 		return
 	}
 
 	if *showConfig {
-		fmt.Print(cfg.String())
+		if *profile != "" {
+			cfg, _ = cfg.Profile(*profile)
+		}
+		for _, w := range cfg.Warnings() {
+			fmt.Fprintf(os.Stderr, "⚠️  %s\n", w)
+		}
+		if jsonOutput {
+			data, err := cfg.JSON()
+			if err != nil {
+				fail("error", err)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		fmt.Print(cfg.Annotated())
 		return
 	}
 
 	c := cleaner.New(cfg, *dryRun, *force)
+	if *profile != "" {
+		c.SetProfile(*profile)
+	}
+	if d, ok := durations["wait"]; ok {
+		c.SetWait(d)
+	}
+	c.SetLockTimeout(durations["lock-timeout"])
+	c.SetOutput(progress)
 	if err := c.Run(); err != nil {
+		fail("error", err)
+	}
+	if jsonOutput {
+		emit(c.Report())
+	}
+}
+
+// validate reports the outcome of loading the configuration, exiting non-zero
+// if it has problems.
+func validate(err error) {
+	problems := config.Problems(err)
+	if err != nil && problems == nil {
+		fail("error loading config", err)
+	}
+	file := config.File()
+	var files []string
+	for _, f := range config.Files() {
+		if _, statErr := os.Stat(f); statErr == nil {
+			files = append(files, f)
+		}
+	}
+	if jsonOutput {
+		emit(struct {
+			Valid    bool             `json:"valid"`
+			File     string           `json:"file"`
+			Files    []string         `json:"files"`
+			Problems []config.Problem `json:"problems"`
+		}{err == nil, file, append([]string{}, files...), append([]config.Problem{}, problems...)})
+	} else if err != nil {
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "❌ %s\n", p)
+		}
+	} else if len(files) == 0 {
+		fmt.Printf("✓ No config file at %s; the defaults are valid\n", file)
+	} else if len(files) == 1 {
+		fmt.Printf("✓ %s is valid\n", files[0])
+	} else {
+		fmt.Printf("✓ %s are valid\n", strings.Join(files, " and "))
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// exitUnresolved is the exit code of an unattended --recommend that leaves
+// findings unresolved.
+const exitUnresolved = 3
+
+// jsonOutput is set by --output json: every command then prints a single JSON
+// document on stdout, errors included.
+var jsonOutput bool
+
+// emit prints v as an indented JSON document.
+func emit(v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
+	fmt.Println(string(data))
+}
+
+// fail reports err, prefixed with what failed in text mode, and exits.
+func fail(prefix string, err error) {
+	if jsonOutput {
+		emit(map[string]string{"error": err.Error()})
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", prefix, err)
+	}
+	os.Exit(1)
 }